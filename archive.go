@@ -0,0 +1,244 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/ungerik/go-dry"
+)
+
+// _ARCHIVE_SUFFIXES are the plugins downloaded instead of cloned, e.g. from
+// the release page of an internal server
+var _ARCHIVE_SUFFIXES = []string{".tar.gz", ".tgz", ".zip"}
+
+// _DOWNLOAD_HOSTS serve files, not git repositories, a plugin there is
+// downloaded whatever its suffix, e.g. a single script from vim.org or a raw
+// file of github
+var _DOWNLOAD_HOSTS = map[string]bool{
+	"www.vim.org":                true,
+	"vim.org":                    true,
+	"raw.githubusercontent.com":  true,
+	"gist.githubusercontent.com": true,
+}
+
+// _VIM_RUNTIME_DIRS are the directories of a plugin, an archive whose only top
+// level directory is one of them has no directory to strip
+var _VIM_RUNTIME_DIRS = map[string]bool{
+	"autoload/": true, "colors/": true, "compiler/": true, "doc/": true, "ftdetect/": true,
+	"ftplugin/": true, "indent/": true, "keymap/": true, "lua/": true, "plugin/": true,
+	"syntax/": true,
+}
+
+// _VIM_ORG_SCRIPT_PATTERN matches the download links of vim.org, which tell
+// nothing but the id of the script version
+var _VIM_ORG_SCRIPT_PATTERN = regexp.MustCompile("/download_script\\.php\\?src_id=(\\d+)")
+
+func isArchiveURL(url string) bool {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return false
+	}
+	return _DOWNLOAD_HOSTS[urlHost(url)] || archiveSuffix(url) != ""
+}
+
+func archiveSuffix(url string) string {
+	for _, suffix := range _ARCHIVE_SUFFIXES {
+		if strings.HasSuffix(url, suffix) {
+			return suffix
+		}
+	}
+	return ""
+}
+
+// archiveName returns the plugin name of a download url
+func archiveName(url string) string {
+	if ss := _VIM_ORG_SCRIPT_PATTERN.FindStringSubmatch(url); len(ss) > 0 {
+		return "vim-script-" + ss[1]
+	}
+	if p := strings.IndexAny(url, "?#"); p >= 0 {
+		url = url[:p]
+	}
+	name := path.Base(strings.TrimRight(url, "/"))
+	if suffix := archiveSuffix(name); suffix != "" {
+		return strings.TrimSuffix(name, suffix)
+	}
+	return strings.TrimSuffix(name, ".vim")
+}
+
+// installArchive downloads a plugin archive through the cache and unpacks it
+// into installDir, a single top level directory of the archive is stripped,
+// unless it is plugin/ or another runtime directory, and a lone .vim file
+// goes to plugin/
+func (app *_appContext) installArchive(url, installDir string) error {
+	app.info("Downloading", url)
+	cached, err := app.cachedDownload(url)
+	if err != nil {
+		return err
+	}
+	os.RemoveAll(installDir)
+	if err := os.MkdirAll(installDir, 0755); err != nil {
+		return err
+	}
+
+	// the downloads of vim.org have no suffix, tell them by their content
+	switch archiveType(cached) {
+	case ".zip":
+		err = unzipPlugin(cached, installDir)
+	case ".tar.gz":
+		err = untarPlugin(cached, installDir)
+	default:
+		os.MkdirAll(path.Join(installDir, "plugin"), 0755)
+		err = dry.FileCopy(cached, path.Join(installDir, "plugin", path.Base(installDir)+".vim"))
+	}
+	if err != nil {
+		os.RemoveAll(installDir)
+	}
+	return err
+}
+
+// archiveType tells a zip or a tar.gz file by its magic number, anything else
+// is a vim script
+func archiveType(file string) string {
+	f, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	n, _ := io.ReadFull(f, magic)
+	switch {
+	case n == 4 && string(magic) == "PK\x03\x04":
+		return ".zip"
+	case n >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		return ".tar.gz"
+	}
+	return ""
+}
+
+// archivePath returns where an archive entry goes inside the plugin dir, or
+// empty to skip it
+func archivePath(name, prefix string) (string, error) {
+	name = path.Clean(strings.TrimPrefix(strings.TrimPrefix(name, "./"), prefix))
+	if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("invalid path in the archive: %s", name)
+	}
+	if name == "." || name == "" {
+		return "", nil
+	}
+	return name, nil
+}
+
+// archivePrefix returns the top level directory shared by all the entries,
+// neither '..' nor the root nor a runtime directory of vim
+func archivePrefix(names []string) string {
+	prefix := ""
+	for _, name := range names {
+		name = strings.TrimPrefix(name, "./")
+		p := strings.Index(name, "/")
+		if p < 0 {
+			return ""
+		}
+		if prefix == "" {
+			prefix = name[:p+1]
+		} else if name[:p+1] != prefix {
+			return ""
+		}
+	}
+	if prefix == "/" || prefix == "../" || _VIM_RUNTIME_DIRS[prefix] {
+		return ""
+	}
+	return prefix
+}
+
+func untarPlugin(archive, installDir string) error {
+	names := []string{}
+	err := readTarGz(archive, func(header *tar.Header, r io.Reader) error {
+		names = append(names, header.Name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	prefix := archivePrefix(names)
+	return readTarGz(archive, func(header *tar.Header, r io.Reader) error {
+		name, err := archivePath(header.Name, prefix)
+		if err != nil || name == "" {
+			return err
+		}
+		target := path.Join(installDir, name)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			return os.MkdirAll(target, 0755)
+		case tar.TypeReg, tar.TypeRegA:
+			return extractTarFile(r, target, os.FileMode(header.Mode).Perm())
+		}
+		return nil
+	})
+}
+
+func readTarGz(archive string, fn func(*tar.Header, io.Reader) error) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(header, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func unzipPlugin(archive, installDir string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	prefix := archivePrefix(names)
+	for _, f := range zr.File {
+		name, err := archivePath(f.Name, prefix)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+		target := path.Join(installDir, name)
+		if f.FileInfo().IsDir() {
+			os.MkdirAll(target, 0755)
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = extractTarFile(r, target, f.Mode().Perm()|0600)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testArchive writes a tar.gz or a zip of the entries, the names ending with
+// '/' are directories
+func testArchive(t *testing.T, file string, entries []string) {
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if strings.HasSuffix(file, ".zip") {
+		zw := zip.NewWriter(f)
+		for _, name := range entries {
+			w, err := zw.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(name, "/") {
+				w.Write([]byte(name))
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, name := range entries {
+		header := &tar.Header{Name: name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(name))}
+		if strings.HasSuffix(name, "/") {
+			header.Mode, header.Typeflag, header.Size = 0755, tar.TypeDir, 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			tw.Write([]byte(name))
+		}
+	}
+	tw.Close()
+	gz.Close()
+}
+
+// testFiles returns the files under dir, with their content
+func testFiles(t *testing.T, dir string) string {
+	files := []string{}
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			data, _ := ioutil.ReadFile(p)
+			files = append(files, strings.TrimPrefix(p, dir+"/")+"="+string(data))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return strings.Join(files, " ")
+}
+
+func TestArchivePath(t *testing.T) {
+	for _, tc := range []struct {
+		name, prefix string
+		path         string
+		invalid      bool
+	}{
+		{"plugin/x.vim", "", "plugin/x.vim", false},
+		{"./plugin/x.vim", "", "plugin/x.vim", false},
+		{"x-1.0/plugin/x.vim", "x-1.0/", "plugin/x.vim", false},
+		{"./x-1.0/plugin/x.vim", "x-1.0/", "plugin/x.vim", false},
+		{"x-1.0/", "x-1.0/", "", false},
+		{"./", "", "", false},
+		{"plugin/../doc/x.txt", "", "doc/x.txt", false},
+		{"../x.vim", "", "", true},
+		{"..", "", "", true},
+		{"plugin/../../x.vim", "", "", true},
+		{"x-1.0/../../x.vim", "x-1.0/", "", true},
+		{"/etc/x.vim", "", "", true},
+		{"x-1.0//etc/x.vim", "x-1.0/", "", true},
+	} {
+		p, err := archivePath(tc.name, tc.prefix)
+		if p != tc.path || (err != nil) != tc.invalid {
+			t.Errorf("archivePath(%q, %q) = %q, %v, want %q, invalid %v", tc.name, tc.prefix, p, err, tc.path, tc.invalid)
+		}
+	}
+}
+
+func TestArchivePrefix(t *testing.T) {
+	for _, tc := range []struct {
+		names  string
+		prefix string
+	}{
+		{"x-1.0/ x-1.0/plugin/x.vim x-1.0/doc/x.txt", "x-1.0/"},
+		{"./x-1.0/plugin/x.vim ./x-1.0/doc/x.txt", "x-1.0/"},
+		{"plugin/x.vim doc/x.txt", ""},
+		{"x-1.0/plugin/x.vim README", ""},
+		{"x-1.0/plugin/x.vim y/plugin/y.vim", ""},
+		{"plugin/ plugin/x.vim", ""},
+		{"autoload/x.vim", ""},
+		{"../x/plugin/x.vim ../x/doc/x.txt", ""},
+		{"/x/plugin/x.vim /x/doc/x.txt", ""},
+		{"", ""},
+	} {
+		if prefix := archivePrefix(strings.Fields(tc.names)); prefix != tc.prefix {
+			t.Errorf("archivePrefix(%q) = %q, want %q", tc.names, prefix, tc.prefix)
+		}
+	}
+}
+
+func TestUnpackPlugin(t *testing.T) {
+	for _, tc := range []struct {
+		desc    string
+		entries string
+		files   string
+		invalid bool
+	}{
+		{"single root", "x-1.0/ x-1.0/plugin/ x-1.0/plugin/x.vim x-1.0/doc/x.txt",
+			"doc/x.txt=x-1.0/doc/x.txt plugin/x.vim=x-1.0/plugin/x.vim", false},
+		{"single root with ./", "./x-1.0/plugin/x.vim ./x-1.0/doc/x.txt",
+			"doc/x.txt=./x-1.0/doc/x.txt plugin/x.vim=./x-1.0/plugin/x.vim", false},
+		{"runtime dirs", "plugin/x.vim doc/x.txt",
+			"doc/x.txt=doc/x.txt plugin/x.vim=plugin/x.vim", false},
+		{"single runtime dir", "plugin/ plugin/x.vim",
+			"plugin/x.vim=plugin/x.vim", false},
+		{"files at the top", "x.vim README",
+			"README=README x.vim=x.vim", false},
+		{"parent entry", "plugin/x.vim ../evil.vim", "", true},
+		{"parent entries only", "../x/plugin/x.vim ../x/doc/x.txt", "", true},
+		{"parent inside the root", "x-1.0/plugin/x.vim x-1.0/../../evil.vim", "", true},
+		{"absolute entry", "plugin/x.vim /tmp/evil.vim", "", true},
+	} {
+		for _, suffix := range []string{".tar.gz", ".zip"} {
+			tmp := t.TempDir()
+			archive, installDir := path.Join(tmp, "x"+suffix), path.Join(tmp, "a", "b", "x")
+			testArchive(t, archive, strings.Fields(tc.entries))
+			os.MkdirAll(installDir, 0755)
+			var err error
+			if suffix == ".zip" {
+				err = unzipPlugin(archive, installDir)
+			} else {
+				err = untarPlugin(archive, installDir)
+			}
+			if (err != nil) != tc.invalid {
+				t.Errorf("%s%s: error %v, want invalid %v", tc.desc, suffix, err, tc.invalid)
+			}
+			if files := testFiles(t, path.Join(tmp, "a")); tc.invalid {
+				if strings.Contains(files, "evil") || strings.Contains(testFiles(t, tmp), "evil.vim=") {
+					t.Errorf("%s%s: an entry is written out of the plugin dir: %s", tc.desc, suffix, files)
+				}
+			} else if want := testPrefixFiles("b/x/", tc.files); files != want {
+				t.Errorf("%s%s: files = %s, want %s", tc.desc, suffix, files, want)
+			}
+		}
+	}
+}
+
+// testPrefixFiles prefixes the paths of the 'path=content' files
+func testPrefixFiles(prefix, files string) string {
+	list := strings.Fields(files)
+	for i := range list {
+		list[i] = prefix + list[i]
+	}
+	return strings.Join(list, " ")
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/ungerik/go-dry"
+)
+
+var _AUTOCMD_PATTERN = regexp.MustCompile("^\\s*:?au(?:t|to|toc|tocm|tocmd)?!?\\s+(\\S+)\\s+(\\S+)\\s+(.*)$")
+var _MAP_PATTERN = regexp.MustCompile("^\\s*:?(?:silent!?\\s+)?([nvxsoilct]?)(nore)?map(!?)\\s+((?:<(?:buffer|silent|expr|nowait|unique|script|special)>\\s*)*)(\\S+)\\s+(.*)$")
+var _SET_PATTERN = regexp.MustCompile("^\\s*:?se(?:t)?\\s+(.*)$")
+var _LET_PATTERN = regexp.MustCompile("^\\s*:?let\\s+([A-Za-z_:][A-Za-z0-9_:#.]*)\\s*([-+.*/]?=)\\s*(.*)$")
+var _IF_PATTERN = regexp.MustCompile("^\\s*:?if\\b")
+var _ENDIF_PATTERN = regexp.MustCompile("^\\s*:?en(?:d|di|dif)?\\b")
+var _FUNCTION_PATTERN = regexp.MustCompile("^\\s*:?fu(?:n|nc|nct|ncti|nctio|nction)?!?\\s")
+var _ENDFUNCTION_PATTERN = regexp.MustCompile("^\\s*:?endf(?:u|un|unc|unct|uncti|unctio|unction)?\\b")
+var _KEY_NOTATION_PATTERN = regexp.MustCompile("<[^<>]+>")
+
+// _MAP_MODES are the modes of the map commands without a mode prefix
+var _MAP_MODES = map[string][]string{
+	"":  {"n", "x", "s", "o"},
+	"!": {"i", "c"},
+	"v": {"x", "s"},
+}
+
+// _BUILTIN_NORMAL_KEYS are the normal mode commands often shadowed by mistake
+var _BUILTIN_NORMAL_KEYS = map[string]bool{
+	"gd": true, "gD": true, "gf": true, "gg": true, "gv": true, "gJ": true,
+	"K": true, "J": true, "Q": true, "U": true, "Y": true, "S": true, "s": true,
+	"<c-a>": true, "<c-e>": true, "<c-n>": true, "<c-p>": true, "<c-r>": true,
+	"<c-s>": true, "<c-v>": true, "<c-w>": true, "<c-x>": true, "<c-y>": true,
+}
+
+// _OPTION_NAMES resolves the short names of the common options
+var _OPTION_NAMES = map[string]string{
+	"ai": "autoindent", "bs": "backspace", "cin": "cindent", "cino": "cinoptions",
+	"enc": "encoding", "et": "expandtab", "fcs": "fillchars", "gfn": "guifont",
+	"is": "incsearch", "lbr": "linebreak", "ls": "laststatus", "mat": "matchtime",
+	"nu": "number", "rnu": "relativenumber", "sc": "showcmd", "si": "smartindent",
+	"sm": "showmatch", "so": "scrolloff", "ss": "sidescroll", "sta": "smarttab",
+	"sts": "softtabstop", "sw": "shiftwidth", "tenc": "termencoding", "ts": "tabstop",
+	"tw": "textwidth", "hls": "hlsearch", "ic": "ignorecase",
+	"scs": "smartcase", "bg": "background", "ff": "fileformat", "fenc": "fileencoding",
+}
+
+// auditEntry is a mapping or an assignment found in a vim config
+type auditEntry struct {
+	path        string
+	line        int
+	text        string
+	value       string
+	conditional bool
+}
+
+var auditCommand = cli.Command{
+	Name:  "audit",
+	Usage: "report key mappings and settings defined by more than one sourced vim config",
+	Action: func(c *cli.Context) {
+		app := getApp(c)
+		files, err := app.sourcedConfigs()
+		if err != nil {
+			app.fatal("unable to read vim configs (error: %s)", err)
+		}
+		conflicts := 0
+		for _, kind := range []string{"mapping", "option", "variable"} {
+			entries := make(map[string][]auditEntry)
+			for _, file := range files {
+				auditVimConfig(file, kind, entries)
+			}
+			keys := []string{}
+			for key, list := range entries {
+				if len(list) > 1 {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				conflicts++
+				if app.jsonOutput {
+					app.event("conflict", auditConflictFields(kind, key, entries[key]))
+				} else {
+					printAuditConflict(kind, key, entries[key])
+				}
+			}
+		}
+		if conflicts > 0 {
+			app.warn("%d conflict(s) in %d sourced vim config(s)", conflicts, len(files))
+		} else {
+			app.success("no conflict in %d sourced vim config(s)", len(files))
+		}
+	},
+}
+
+func printAuditConflict(kind, key string, list []auditEntry) {
+	fmt.Printf("%s %s:\n", kind, key)
+	for i, e := range list {
+		note := ""
+		if i == len(list)-1 {
+			note = "  <- wins"
+		} else if e.value == list[len(list)-1].value {
+			note = "  (same value)"
+		}
+		if e.conditional {
+			note += " (inside if)"
+		}
+		fmt.Printf("  %s:%d: %s%s\n", path.Base(e.path), e.line, e.text, note)
+	}
+	if fields := strings.Fields(key); kind == "mapping" && fields[0] == "n" && _BUILTIN_NORMAL_KEYS[fields[1]] {
+		fmt.Printf("  note: shadows the builtin %s of normal mode\n", fields[1])
+	}
+}
+
+func auditConflictFields(kind, key string, list []auditEntry) eventFields {
+	sites := []eventFields{}
+	for _, e := range list {
+		sites = append(sites, eventFields{"path": e.path, "line": e.line, "text": e.text, "conditional": e.conditional})
+	}
+	return eventFields{"kind": kind, "key": key, "definitions": sites, "winner": sites[len(sites)-1]}
+}
+
+// sourcedConfigs returns the vim configs in the order the generated .vimrc
+// sources them, as installPluginsByConfigs writes it
+func (app *_appContext) sourcedConfigs() ([]string, error) {
+	profile, err := app.profileConfigs()
+	if err != nil {
+		return nil, err
+	}
+	inProfile := func(name string) bool {
+		return profile == nil || profile[name]
+	}
+	files := []string{}
+	if common := app.resolveConfigs()["common.vimrc"]; common != nil && common.path != "" {
+		if inProfile(common.name) {
+			files = append(files, common.path)
+		}
+	} else if oldVimrc := path.Join(app.configDir, "_old_config.vimrc"); dry.FileExists(oldVimrc) && inProfile(path.Base(oldVimrc)) {
+		files = append(files, oldVimrc)
+	}
+	configs, err := app.parseVimConfigs()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := app.loadManifest()
+	if err != nil {
+		return nil, err
+	}
+	plan := app.planPlugins(manifest, configs)
+	for _, config := range configs {
+		if inProfile(config.name) && !plan.disabledConfigs[config.name] {
+			files = append(files, config.path)
+		}
+	}
+	return files, nil
+}
+
+// auditVimConfig collects the mappings, options or variables of a vim config
+// into entries by key, in source order
+func auditVimConfig(file, kind string, entries map[string][]auditEntry) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	depth, inFunction := 0, false
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		text := strings.TrimSpace(scanner.Text())
+		line, scope := text, ""
+		if ss := _AUTOCMD_PATTERN.FindStringSubmatch(line); ss != nil {
+			line, scope = ss[3], " ("+ss[1]+" "+ss[2]+")"
+		}
+		switch {
+		case _FUNCTION_PATTERN.MatchString(line):
+			inFunction = true
+			continue
+		case _ENDFUNCTION_PATTERN.MatchString(line):
+			inFunction = false
+			continue
+		case inFunction:
+			// the body runs when the function is called, not when sourced
+			continue
+		case _IF_PATTERN.MatchString(line):
+			depth++
+			continue
+		case _ENDIF_PATTERN.MatchString(line) && depth > 0:
+			depth--
+			continue
+		}
+		add := func(key, value string) {
+			entries[key] = append(entries[key], auditEntry{file, lineNo, text, value, depth > 0})
+		}
+
+		switch kind {
+		case "mapping":
+			ss := _MAP_PATTERN.FindStringSubmatch(line)
+			if ss == nil || strings.Contains(ss[4], "<buffer>") {
+				continue
+			}
+			modes, ok := _MAP_MODES[ss[1]+ss[3]]
+			if !ok {
+				modes = []string{ss[1]}
+			}
+			for _, mode := range modes {
+				add(mode+" "+normalizeKeys(ss[5])+scope, ss[6])
+			}
+		case "option":
+			ss := _SET_PATTERN.FindStringSubmatch(line)
+			if ss == nil {
+				continue
+			}
+			for _, opt := range splitSetArgs(ss[1]) {
+				if name, value, ok := parseSetArg(opt); ok {
+					add(name+scope, value)
+				}
+			}
+		case "variable":
+			ss := _LET_PATTERN.FindStringSubmatch(line)
+			if ss == nil || ss[2] != "=" {
+				continue
+			}
+			name := ss[1]
+			if strings.HasPrefix(name, "s:") || strings.HasPrefix(name, "l:") || strings.HasPrefix(name, "a:") {
+				continue
+			}
+			if !strings.Contains(name, ":") {
+				name = "g:" + name
+			}
+			add(name+scope, strings.TrimSpace(ss[3]))
+		}
+	}
+}
+
+// normalizeKeys makes the key notations comparable, e.g. <leader> and <Leader>
+func normalizeKeys(lhs string) string {
+	return _KEY_NOTATION_PATTERN.ReplaceAllStringFunc(lhs, strings.ToLower)
+}
+
+// splitSetArgs splits the arguments of ':set' at the unescaped spaces and
+// drops the trailing comment
+func splitSetArgs(args string) []string {
+	result := []string{}
+	cur := strings.Builder{}
+	escaped := false
+	for _, r := range args {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			cur.WriteRune(r)
+			escaped = true
+		case r == '"':
+			if cur.Len() > 0 {
+				result = append(result, cur.String())
+			}
+			return result
+		case r == ' ' || r == '\t':
+			if cur.Len() > 0 {
+				result = append(result, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		result = append(result, cur.String())
+	}
+	return result
+}
+
+// parseSetArg returns the full option name and the value set by an argument
+// of ':set', the relative ones like '+=' don't override and are skipped
+func parseSetArg(arg string) (string, string, bool) {
+	if p := strings.IndexAny(arg, "=:"); p > 0 {
+		if strings.ContainsAny(arg[p-1:p], "+-^") {
+			return "", "", false
+		}
+		return optionName(arg[:p]), arg[p+1:], true
+	}
+	name := strings.TrimRight(arg, "!&?")
+	if name != arg {
+		return "", "", false
+	}
+	if strings.HasPrefix(name, "inv") {
+		return "", "", false
+	}
+	if strings.HasPrefix(name, "no") && len(name) > 2 {
+		return optionName(name[2:]), "off", true
+	}
+	return optionName(name), "on", true
+}
+
+func optionName(name string) string {
+	if full, ok := _OPTION_NAMES[name]; ok {
+		return full
+	}
+	return name
+}
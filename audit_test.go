@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testAudit audits config for kind and returns the entries as
+// 'key: line[?] ...', '?' marking the conditional ones
+func testAudit(t *testing.T, config, kind string) string {
+	file := path.Join(t.TempDir(), "test.vimrc")
+	if err := ioutil.WriteFile(file, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string][]auditEntry)
+	auditVimConfig(file, kind, entries)
+	keys := []string{}
+	for key, list := range entries {
+		sites := []string{}
+		for _, e := range list {
+			site := fmt.Sprint(e.line)
+			if e.conditional {
+				site += "?"
+			}
+			sites = append(sites, site)
+		}
+		keys = append(keys, key+": "+strings.Join(sites, " "))
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "\n")
+}
+
+func TestAuditMappings(t *testing.T) {
+	for _, tc := range []struct {
+		desc    string
+		config  string
+		entries string
+	}{
+		{"map", "map <Leader>a :A<CR>\n",
+			"n <leader>a: 1\no <leader>a: 1\ns <leader>a: 1\nx <leader>a: 1"},
+		{"map!", "map! <C-a> <Home>\n",
+			"c <c-a>: 1\ni <c-a>: 1"},
+		{"noremap", "noremap Y y$\nnnoremap Y yy\n",
+			"n Y: 1 2\no Y: 1\ns Y: 1\nx Y: 1"},
+		{"noremap!", "noremap! <c-e> <End>\ninoremap <C-E> <c-o>$\n",
+			"c <c-e>: 1\ni <c-e>: 1 2"},
+		{"vmap", "vmap < <gv\nxnoremap < <gv\n",
+			"s <: 1\nx <: 1 2"},
+		{"modifiers", "silent! nnoremap <silent> <leader>w :w<CR>\nnmap <expr> <leader>w 'x'\n",
+			"n <leader>w: 1 2"},
+		{"buffer", "nnoremap <buffer> K :Doc<CR>\nnnoremap <silent> <buffer> K :Doc<CR>\nnnoremap K :Man<CR>\n",
+			"n K: 3"},
+		{"autocmd", "au FileType go nmap <Leader>i <Plug>(go-info)\nautocmd FileType go nmap <leader>i <Plug>(go-info)\nnmap <leader>i :I<CR>\n",
+			"n <leader>i (FileType go): 1 2\nn <leader>i: 3"},
+		{"if", "if has('gui')\n  nmap Q :q<CR>\nendif\nnmap Q gq\n",
+			"n Q: 2? 4"},
+		{"nested if", "if 1\n  if 2\n    nmap Q a\n  endif\n  nmap Q b\nendif\nnmap Q c\n",
+			"n Q: 3? 5? 7"},
+		{"function", "function! s:Setup()\n  if 1\n    nmap Q a\n  endif\nendfunction\nnmap Q b\nfu Other()\n  nmap Q c\nendf\nnmap Q d\n",
+			"n Q: 6 10"},
+		{"if around a function", "if 1\n  function! F()\n    nmap Q a\n  endfunction\n  nmap Q b\nendif\nnmap Q c\n",
+			"n Q: 5? 7"},
+	} {
+		if entries := testAudit(t, tc.config, "mapping"); entries != tc.entries {
+			t.Errorf("%s: entries =\n%s\nwant\n%s", tc.desc, entries, tc.entries)
+		}
+	}
+}
+
+func TestAuditOptionsAndVariables(t *testing.T) {
+	options := testAudit(t, "set sw=4 ts=4\nset shiftwidth=2 \" two\nset tabstop+=2\nset nonu\nset number\nset invnumber\nse et\nset noexpandtab sw?\n", "option")
+	if want := "expandtab: 7 8\nnumber: 4 5\nshiftwidth: 1 2\ntabstop: 1"; options != want {
+		t.Errorf("options =\n%s\nwant\n%s", options, want)
+	}
+	variables := testAudit(t, "let g:a = 1\nlet a = 2\nlet g:a += 1\nlet s:a = 3\nlet b:a = 4\nif 1\n  let b:a = 5\nendif\n", "variable")
+	if want := "b:a: 5 7?\ng:a: 1 2"; variables != want {
+		t.Errorf("variables =\n%s\nwant\n%s", variables, want)
+	}
+}
+
+func TestAuditGoConfig(t *testing.T) {
+	entries := make(map[string][]auditEntry)
+	auditVimConfig(path.Join("vim-configs", "go.vimrc"), "mapping", entries)
+	list := entries["n <leader>i (FileType go)"]
+	if len(list) != 2 || list[0].line != 28 || list[1].line != 51 {
+		t.Errorf("the duplicate <Leader>i of go.vimrc = %+v", list)
+	}
+}
+
+func TestSplitSetArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args  string
+		split []string
+	}{
+		{"sw=4 ts=4", []string{"sw=4", "ts=4"}},
+		{"  sw=4\tts=4  ", []string{"sw=4", "ts=4"}},
+		{"sw=4 \" indent", []string{"sw=4"}},
+		{"\" only a comment", []string{}},
+		{"fillchars=vert:\\ ,fold:- nu", []string{"fillchars=vert:\\ ,fold:-", "nu"}},
+		{"titlestring=a\\\"b nu", []string{"titlestring=a\\\"b", "nu"}},
+		{"", []string{}},
+	} {
+		if split := splitSetArgs(tc.args); fmt.Sprintf("%q", split) != fmt.Sprintf("%q", tc.split) {
+			t.Errorf("splitSetArgs(%q) = %q, want %q", tc.args, split, tc.split)
+		}
+	}
+}
+
+func TestParseSetArg(t *testing.T) {
+	for _, tc := range []struct {
+		arg   string
+		name  string
+		value string
+		ok    bool
+	}{
+		{"sw=4", "shiftwidth", "4", true},
+		{"shiftwidth=4", "shiftwidth", "4", true},
+		{"ts:8", "tabstop", "8", true},
+		{"nu", "number", "on", true},
+		{"nonu", "number", "off", true},
+		{"nowrap", "wrap", "off", true},
+		{"no", "no", "on", true},
+		{"invnumber", "", "", false},
+		{"nu!", "", "", false},
+		{"nu&", "", "", false},
+		{"sw?", "", "", false},
+		{"sw+=2", "", "", false},
+		{"fo-=t", "", "", false},
+		{"cpo^=x", "", "", false},
+		{"fillchars=vert:\\ ", "fillchars", "vert:\\ ", true},
+	} {
+		name, value, ok := parseSetArg(tc.arg)
+		if name != tc.name || value != tc.value || ok != tc.ok {
+			t.Errorf("parseSetArg(%q) = %q, %q, %v, want %q, %q, %v", tc.arg, name, value, ok, tc.name, tc.value, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// hostCredential tells how to authenticate to a git host, declared in the
+// 'credentials' of settings.yml. The secrets stay out of the settings: tokens
+// are read from environment variables and '$VAR' in header is expanded when
+// the request is sent.
+//
+//	credentials:
+//	  - host: git.corp.example
+//	    ssh_key: ~/.ssh/corp_ed25519
+//	    token_env: CORP_GIT_TOKEN
+//	    header: "PRIVATE-TOKEN: $CORP_GIT_TOKEN"
+type hostCredential struct {
+	Host     string `yaml:"host"`
+	SSHKey   string `yaml:"ssh_key,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	TokenEnv string `yaml:"token_env,omitempty"`
+	Helper   string `yaml:"helper,omitempty"`
+	Header   string `yaml:"header,omitempty"`
+}
+
+// _ASKPASS_SCRIPT answers the prompts of git with the username and the token
+// of the environment variable named by VIM_PLUGIN_SETUP_TOKEN_ENV, so the
+// token itself is never written to disk
+const _ASKPASS_SCRIPT = `#!/bin/sh
+case "$1" in
+Username*) printf '%s\n' "$VIM_PLUGIN_SETUP_USERNAME" ;;
+*) printenv "$VIM_PLUGIN_SETUP_TOKEN_ENV" ;;
+esac
+`
+
+// urlHost returns the host of a git url, or empty for local repositories
+func urlHost(url string) string {
+	if ss := _GIT_SSH_PATTERN.FindStringSubmatch(url); len(ss) > 0 && !_URL_SCHEME_PATTERN.MatchString(url) {
+		return ss[1]
+	}
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// credentialFor returns the credential configured for the host of url
+func (app *_appContext) credentialFor(url string) *hostCredential {
+	host := urlHost(url)
+	if host == "" {
+		return nil
+	}
+	for i, c := range app.settings.Credentials {
+		if c.Host == host {
+			return &app.settings.Credentials[i]
+		}
+	}
+	return nil
+}
+
+// gitRemoteCommand prepares a git command accessing the remote url, with the
+// credential of its host
+func (app *_appContext) gitRemoteCommand(url, dir string, args ...string) *exec.Cmd {
+	args = append(app.gitNetworkArgs(), args...)
+	cred := app.credentialFor(app.mirrorURL(url))
+	if cred == nil {
+		cmd := app.gitCommand(dir, args...)
+		if env := app.gitNetworkEnv(); env != nil {
+			cmd.Env = append(os.Environ(), env...)
+		}
+		return cmd
+	}
+	if cred.Helper != "" {
+		// replace the helpers of the user config instead of adding to them
+		args = append([]string{"-c", "credential.helper=", "-c", "credential.helper=" + cred.Helper}, args...)
+	}
+	cmd := app.gitCommand(dir, args...)
+	cmd.Env = append(append(os.Environ(), app.gitNetworkEnv()...), "GIT_TERMINAL_PROMPT=0")
+	if cred.SSHKey != "" {
+		cmd.Env = append(cmd.Env, "GIT_SSH_COMMAND=ssh -i "+shellQuote(expandHome(cred.SSHKey))+" -o IdentitiesOnly=yes -o BatchMode=yes")
+	}
+	if cred.TokenEnv != "" {
+		if askpass, err := app.askpassScript(); err != nil {
+			app.warn("unable to write askpass script (error: %s)", err)
+		} else {
+			username := cred.Username
+			if username == "" {
+				username = "oauth2"
+			}
+			cmd.Env = append(cmd.Env,
+				"GIT_ASKPASS="+askpass,
+				"VIM_PLUGIN_SETUP_USERNAME="+username,
+				"VIM_PLUGIN_SETUP_TOKEN_ENV="+cred.TokenEnv,
+			)
+		}
+		if os.Getenv(cred.TokenEnv) == "" {
+			app.warn("%s is not set, for the token of %s", cred.TokenEnv, cred.Host)
+		}
+	}
+	return cmd
+}
+
+func (app *_appContext) askpassScript() (string, error) {
+	script := path.Join(app.tmpDir, "git-askpass.sh")
+	if _, err := os.Stat(script); err == nil {
+		return script, nil
+	}
+	os.MkdirAll(app.tmpDir, 0755)
+	return script, ioutil.WriteFile(script, []byte(_ASKPASS_SCRIPT), 0700)
+}
+
+// httpGet downloads url, or its mirror, through the network settings with the
+// header configured for its host
+func (app *_appContext) httpGet(url string) (*http.Response, error) {
+	url = app.mirrorURL(url)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cred := app.credentialFor(url); cred != nil && cred.Header != "" {
+		if p := strings.Index(cred.Header, ":"); p > 0 {
+			req.Header.Set(strings.TrimSpace(cred.Header[:p]), strings.TrimSpace(os.ExpandEnv(cred.Header[p+1:])))
+		} else {
+			app.warn("invalid header of %s, it should be 'Name: value'", cred.Host)
+		}
+	}
+	return app.httpClient().Do(req)
+}
+
+func expandHome(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if u, err := user.Current(); err == nil {
+			return path.Join(u.HomeDir, p[1:])
+		}
+	}
+	return p
+}
+
+var _URL_PASSWORD_PATTERN = regexp.MustCompile("(://[^/@:\\s]*):[^/@\\s]*@")
+
+// _URL_USERINFO_PATTERN matches the user part of http urls, which is a token
+// as often as a username, e.g. https://ghp_xxx@github.com/org/repo; the user
+// of ssh urls is kept, it's no secret
+var _URL_USERINFO_PATTERN = regexp.MustCompile("(?i)(https?://)[^/@\\s]+@")
+
+// redactSecrets hides the passwords and tokens embedded in the urls of a
+// message before it's printed
+func redactSecrets(s string) string {
+	s = _URL_USERINFO_PATTERN.ReplaceAllString(s, "$1***@")
+	return _URL_PASSWORD_PATTERN.ReplaceAllString(s, "$1:***@")
+}
+
+// stripSecret removes the user and password of a url before it's recorded in
+// states, the credentials of settings.yml are used to access it again
+func stripSecret(url string) string {
+	url = _URL_USERINFO_PATTERN.ReplaceAllString(url, "$1")
+	return _URL_PASSWORD_PATTERN.ReplaceAllString(url, "$1@")
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/ungerik/go-dry"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	_BUNDLE_META  = "bundle.yml"
+	_BUNDLE_VIMRC = "vimrc"
+)
+
+// _BUNDLE_ENTRIES are the parts of the vim dir packaged by 'export'
+var _BUNDLE_ENTRIES = []string{
+	"bundle",
+	"autoload/pathogen.vim",
+	"configs",
+	".bundled",
+	"sources",
+	"plugins.yml",
+	"profiles.yml",
+	"states.yml",
+}
+
+// bundleMeta records where a bundle is exported from, so the paths in the
+// generated .vimrc can be rewritten on the host importing it
+type bundleMeta struct {
+	Home      string    `yaml:"home"`
+	VimDir    string    `yaml:"vim_dir"`
+	Vimrc     string    `yaml:"vimrc"`
+	CreatedAt time.Time `yaml:"created_at"`
+}
+
+var exportCommand = cli.Command{
+	Name:  "export",
+	Usage: "package plugins, configs and states into a tarball for air-gapped hosts",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "output,o",
+			Usage: "path of the tarball",
+			Value: "vim-setup.tar.gz",
+		},
+		cli.BoolFlag{
+			Name:  "strip-git",
+			Usage: "leave out the .git of plugin checkouts",
+		},
+	},
+	Action: func(c *cli.Context) {
+		app := getApp(c)
+		app.requireStates()
+		if err := app.lockVimDir(); err != nil {
+			app.fatal("unable to lock %s (%s)", app.vimDir, err)
+		}
+		defer app.unlockVimDir()
+		app.statesErr = app.loadStates()
+		app.requireStates()
+		output := c.String("output")
+		if dry.FileExists(output) && !app.forceUpdate {
+			app.fatal("%s already exists, use --force to overwrite it", output)
+		}
+		if err := app.exportBundle(output, c.Bool("strip-git")); err != nil {
+			os.Remove(output)
+			app.fatal("unable to export %s (error: %s)", output, err)
+		}
+		app.event("file.written", eventFields{"path": output})
+		app.success("vim setup is exported to %s", output)
+	},
+}
+
+var importBundleCommand = cli.Command{
+	Name:      "import-bundle",
+	Usage:     "unpack a tarball made by 'export' into the vim directory",
+	ArgsUsage: "<tarball>",
+	Action: func(c *cli.Context) {
+		if len(c.Args()) != 1 {
+			getApp(c).fatal("missing tarball")
+		}
+		app, done := beginUpdate(c)
+		defer done()
+		if stateFileExists(app.stateFile()) && !app.forceUpdate {
+			app.fatal("%s is set up already, use --force to overwrite it", app.vimDir)
+		}
+		if err := app.importBundle(c.Args().First()); err != nil {
+			app.fatal("unable to import %s (error: %s)", c.Args().First(), err)
+		}
+		app.success("vim setup is imported, run '%s setup' to run the scripts of vim configs", app.cmdName)
+	},
+}
+
+func (app *_appContext) exportBundle(output string, stripGit bool) error {
+	file, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	u, err := user.Current()
+	if err != nil {
+		return err
+	}
+	meta, err := yaml.Marshal(&bundleMeta{
+		Home:      u.HomeDir,
+		VimDir:    app.vimDir,
+		Vimrc:     app.vimrcPath,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, _BUNDLE_META, meta); err != nil {
+		return err
+	}
+	if data, err := ioutil.ReadFile(app.vimrcPath); err == nil {
+		if err := writeTarFile(tw, _BUNDLE_VIMRC, data); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range _BUNDLE_ENTRIES {
+		root := path.Join(app.vimDir, entry)
+		if !dry.FileExists(root) {
+			continue
+		}
+		app.debug("export", root)
+		err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if stripGit && entry == "bundle" && info.Name() == ".git" {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			link := ""
+			if info.Mode()&os.ModeSymlink != 0 {
+				if link, err = os.Readlink(p); err != nil {
+					return err
+				}
+				if entry == "bundle" && path.IsAbs(link) {
+					app.warn("skip %s, linked plugins are not exported", p)
+					return nil
+				}
+			} else if !info.IsDir() && !info.Mode().IsRegular() {
+				return nil
+			}
+			header, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return err
+			}
+			header.Name, _ = filepath.Rel(app.vimDir, p)
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(tw, f)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// importBundle unpacks a bundle into the vim dir, rewrites the paths of the
+// exporting host in .vimrc and marks the scripts to run again on this host
+func (app *_appContext) importBundle(tarball string) error {
+	file, err := os.Open(tarball)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+
+	var meta *bundleMeta
+	var vimrc []byte
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid path in the tarball: %s", header.Name)
+		}
+		switch name {
+		case _BUNDLE_META:
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			meta = &bundleMeta{}
+			if err := yaml.Unmarshal(data, meta); err != nil {
+				return err
+			}
+			continue
+		case _BUNDLE_VIMRC:
+			if vimrc, err = ioutil.ReadAll(tr); err != nil {
+				return err
+			}
+			continue
+		}
+
+		target := path.Join(app.vimDir, name)
+		app.debug("import", target)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeSymlink:
+			if link := path.Join(path.Dir(name), header.Linkname); path.IsAbs(header.Linkname) || link == ".." || strings.HasPrefix(link, "../") {
+				return fmt.Errorf("invalid link in the tarball: %s -> %s", header.Name, header.Linkname)
+			}
+			os.MkdirAll(path.Dir(target), 0755)
+			os.RemoveAll(target)
+			err = os.Symlink(header.Linkname, target)
+		case tar.TypeReg, tar.TypeRegA:
+			err = extractTarFile(tr, target, os.FileMode(header.Mode).Perm())
+		default:
+			app.warn("skip %s in the tarball", header.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if meta == nil {
+		return fmt.Errorf("%s is missing, not a tarball made by '%s export'", _BUNDLE_META, app.cmdName)
+	}
+
+	if app.statesErr = app.loadStates(); app.statesErr != nil {
+		return app.statesErr
+	}
+	for _, script := range app.states.Scripts {
+		script.Rerun = true
+		script.LogPath = rewriteBundlePath(script.LogPath, meta.VimDir, app.vimDir)
+	}
+
+	if vimrc == nil {
+		return nil
+	}
+	u, err := user.Current()
+	if err != nil {
+		return err
+	}
+	data := strings.Replace(string(vimrc), meta.VimDir, app.vimDir, -1)
+	data = strings.Replace(data, meta.Home, u.HomeDir, -1)
+	if dry.FileExists(app.vimrcPath) && !isGeneratedVimrc(app.vimrcPath) {
+		// keep the user defined vimrc like setup does
+		if old, err := ioutil.ReadFile(app.vimrcPath); err == nil {
+			saveConfig(path.Join(app.configDir, "_old_config.vimrc"), old, true, true)
+		}
+	}
+	return ioutil.WriteFile(app.vimrcPath, []byte(data), 0644)
+}
+
+func extractTarFile(r io.Reader, target string, mode os.FileMode) error {
+	os.MkdirAll(path.Dir(target), 0755)
+	os.RemoveAll(target)
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func rewriteBundlePath(p, from, to string) string {
+	if p == from || strings.HasPrefix(p, from+"/") {
+		return to + strings.TrimPrefix(p, from)
+	}
+	return p
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/ungerik/go-dry"
+)
+
+// errOffline is returned instead of accessing the network in offline mode
+var errOffline = errors.New("not in the cache, and the network is not used in offline mode")
+
+var cacheCommand = cli.Command{
+	Name:  "cache",
+	Usage: "manage the cache of plugin repositories and downloads",
+	Subcommands: []cli.Command{
+		{
+			Name:    "list",
+			Usage:   "list cached repositories and downloads",
+			Aliases: []string{"ls"},
+			Action: func(c *cli.Context) {
+				app := getApp(c)
+				entries := app.cacheEntries()
+				var total int64
+				if app.jsonOutput {
+					for _, e := range entries {
+						app.event("cache.entry", eventFields{"name": e.name, "size": e.size, "used": e.used})
+					}
+					return
+				}
+				fmt.Printf("List cache (%s):\n", app.cacheDir)
+				for _, e := range entries {
+					fmt.Printf("  %8s  %s  %s\n", formatSize(e.size), e.used.Format("2006-01-02"), e.name)
+					total += e.size
+				}
+				fmt.Printf("  %8s  total\n", formatSize(total))
+			},
+		},
+		{
+			Name:  "prune",
+			Usage: "remove the least recently used cache entries",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "max-size",
+					Usage: "keep the cache under this size, e.g. 500M or 2G",
+				},
+				cli.DurationFlag{
+					Name:  "older-than",
+					Usage: "remove the entries unused for this long, e.g. 720h",
+				},
+				cli.BoolFlag{
+					Name:  "all",
+					Usage: "remove every cache entry",
+				},
+			},
+			Action: func(c *cli.Context) {
+				app := getApp(c)
+				maxSize := int64(-1)
+				if s := c.String("max-size"); s != "" {
+					size, err := parseSize(s)
+					if err != nil {
+						app.fatal("invalid size: %s", s)
+					}
+					maxSize = size
+				}
+				if c.Bool("all") {
+					maxSize = 0
+				} else if maxSize < 0 && c.Duration("older-than") == 0 {
+					app.fatal("nothing to prune, use --max-size, --older-than or --all")
+				}
+				if err := app.lockCache(); err != nil {
+					app.fatal("unable to lock %s (%s)", app.cacheDir, err)
+				}
+				removed, freed := app.pruneCache(maxSize, c.Duration("older-than"))
+				app.success("removed %d cache entries, %s freed", removed, formatSize(freed))
+			},
+		},
+	},
+}
+
+var _CACHE_NAME_PATTERN = regexp.MustCompile("[^A-Za-z0-9._-]+")
+
+func cacheName(url string) string {
+	if p := strings.Index(url, "://"); p >= 0 {
+		url = url[p+3:]
+	}
+	return strings.Trim(_CACHE_NAME_PATTERN.ReplaceAllString(url, "_"), "_")
+}
+
+func (app *_appContext) mirrorPath(url string) string {
+	return path.Join(app.cacheDir, "git", strings.TrimSuffix(cacheName(stripSecret(url)), ".git")+".git")
+}
+
+// cachedMirror returns a bare mirror of the repository in the cache, it's
+// created or refreshed from the network unless in offline mode
+func (app *_appContext) cachedMirror(url string) (string, error) {
+	if err := app.useCache(); err != nil {
+		return "", err
+	}
+	mirror := app.mirrorPath(url)
+	if app.offline {
+		if !dry.FileIsDir(mirror) {
+			return "", errOffline
+		}
+	} else if err := app.refreshMirror(url, mirror); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	os.Chtimes(mirror, now, now)
+	return mirror, nil
+}
+
+// refreshMirror updates a mirror, or clones it aside and moves it into place
+// so a failed clone leaves nothing behind. The runs sharing the cache take
+// turns on a mirror.
+func (app *_appContext) refreshMirror(url, mirror string) error {
+	lock, err := app.lockCacheFile(path.Join("locks", path.Base(mirror)+".lock"), syscall.LOCK_EX, true)
+	if err != nil {
+		return err
+	}
+	defer unlockCacheFile(lock)
+	if dry.FileIsDir(mirror) {
+		app.debug("refresh mirror", mirror)
+		return app.gitError(app.gitRemoteCommand(url, mirror, "remote", "update", "--prune").Run())
+	}
+	os.MkdirAll(path.Dir(mirror), 0755)
+	tmp, err := ioutil.TempDir(path.Dir(mirror), ".clone-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := app.gitRemoteCommand(url, "", "clone", "--mirror", url, tmp).Run(); err != nil {
+		return app.gitError(err)
+	}
+	return os.Rename(tmp, mirror)
+}
+
+// gitClone clones a repository through the cache. Online the clone borrows the
+// objects of the mirror and dissociates from it, offline it's cloned from the
+// mirror; either way origin points to url. A clone which fails on the network
+// is retried from scratch.
+func (app *_appContext) gitClone(url, dir string) error {
+	return app.retry("clone "+stripSecret(url), func() { os.RemoveAll(dir) }, func() error {
+		return app.gitError(app.gitCloneOnce(url, dir))
+	})
+}
+
+func (app *_appContext) gitCloneOnce(url, dir string) error {
+	mirror, err := app.cachedMirror(url)
+	if err != nil {
+		if app.offline {
+			return err
+		}
+		app.warn("unable to cache %s (error: %s)", url, err)
+		return app.gitRemoteCommand(url, "", "clone", url, dir).Run()
+	}
+	if app.offline {
+		if err := app.gitCommand("", "clone", mirror, dir).Run(); err != nil {
+			return err
+		}
+		return app.gitCommand(dir, "remote", "set-url", "origin", url).Run()
+	}
+	return app.gitRemoteCommand(url, "", "clone", "--reference", mirror, "--dissociate", url, dir).Run()
+}
+
+// gitFetch fetches origin of a checkout, from the cache in offline mode
+func (app *_appContext) gitFetch(dir, url string) error {
+	if !app.offline {
+		return app.retry("fetch "+stripSecret(url), nil, func() error {
+			return app.gitError(app.gitRemoteCommand(url, dir, "fetch", "--tags", "origin").Run())
+		})
+	}
+	mirror, err := app.cachedMirror(url)
+	if err != nil {
+		return err
+	}
+	return app.gitCommand(dir, "fetch", "--tags", mirror, "+refs/heads/*:refs/remotes/origin/*").Run()
+}
+
+// cachedDownload downloads a file into the cache and returns its path, the
+// cached copy is used if the download fails or in offline mode
+func (app *_appContext) cachedDownload(url string) (string, error) {
+	if err := app.useCache(); err != nil {
+		return "", err
+	}
+	cached := path.Join(app.cacheDir, "files", fmt.Sprintf("%x", sha1.Sum([]byte(url)))+"-"+path.Base(url))
+	if app.offline {
+		if !dry.FileExists(cached) {
+			return "", errOffline
+		}
+		return cached, nil
+	}
+
+	err := app.retry("download "+url, nil, func() error {
+		resp, err := app.httpGet(url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return &httpStatusError{resp.Status, resp.StatusCode}
+		}
+		os.MkdirAll(path.Dir(cached), 0755)
+		tmpfile, err := ioutil.TempFile(path.Dir(cached), ".download-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmpfile.Name())
+		defer tmpfile.Close()
+		if _, err := io.Copy(tmpfile, app.trackDownload(resp.Body, resp.ContentLength)); err != nil {
+			return err
+		}
+		if err := tmpfile.Close(); err != nil {
+			return err
+		}
+		return os.Rename(tmpfile.Name(), cached)
+	})
+	if err != nil {
+		if !dry.FileExists(cached) {
+			return "", err
+		}
+		app.warn("unable to download %s (error: %s), use the cached copy", url, err)
+	}
+	now := time.Now()
+	os.Chtimes(cached, now, now)
+	return cached, nil
+}
+
+type cacheEntry struct {
+	name string
+	path string
+	size int64
+	used time.Time
+}
+
+func (app *_appContext) cacheEntries() []cacheEntry {
+	entries := []cacheEntry{}
+	for _, kind := range []string{"git", "files"} {
+		dir := path.Join(app.cacheDir, kind)
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, fi := range fis {
+			if strings.HasPrefix(fi.Name(), ".") {
+				// a clone or a download in progress
+				continue
+			}
+			e := cacheEntry{
+				name: path.Join(kind, fi.Name()),
+				path: path.Join(dir, fi.Name()),
+				used: fi.ModTime(),
+			}
+			filepath.Walk(e.path, func(_ string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					e.size += info.Size()
+				}
+				return nil
+			})
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].used.Before(entries[j].used)
+	})
+	return entries
+}
+
+// pruneCache removes the entries unused for longer than maxAge, then the least
+// recently used ones until the cache fits maxSize; a negative maxSize means no
+// limit and a zero maxAge means no age limit
+func (app *_appContext) pruneCache(maxSize int64, maxAge time.Duration) (int, int64) {
+	entries := app.cacheEntries()
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+	removed, freed := 0, int64(0)
+	for _, e := range entries {
+		tooOld := maxAge > 0 && time.Since(e.used) > maxAge
+		tooBig := maxSize >= 0 && total > maxSize
+		if !tooOld && !tooBig {
+			continue
+		}
+		app.info("remove %s (%s)", e.name, formatSize(e.size))
+		if err := os.RemoveAll(e.path); err != nil {
+			app.err("unable to remove %s (error: %s)", e.path, err)
+			app.fail("cache", e.name, "", err)
+			continue
+		}
+		total -= e.size
+		freed += e.size
+		removed++
+	}
+	return removed, freed
+}
+
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(s), "B"))
+	unit := int64(1)
+	for i, suffix := range []string{"K", "M", "G", "T"} {
+		if strings.HasSuffix(s, suffix) {
+			unit = 1 << (10 * uint(i+1))
+			s = strings.TrimSuffix(s, suffix)
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+func formatSize(size int64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	f := float64(size)
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d%s", size, units[i])
+	}
+	return fmt.Sprintf("%.1f%s", f, units[i])
+}
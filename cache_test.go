@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"sync"
+	"testing"
+)
+
+func TestCachedMirrorShared(t *testing.T) {
+	bare, _ := testSourceRepo(t)
+	cacheDir := t.TempDir()
+	url := "file://" + bare
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			app := testSourceApp(t)
+			app.cacheDir = cacheDir
+			_, errs[i] = app.cachedMirror(url)
+			if app.cacheLock != nil {
+				app.cacheLock.Close()
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := testSourceApp(t)
+	app.cacheDir = cacheDir
+	if entries := app.cacheEntries(); len(entries) != 1 || entries[0].name != "git/"+path.Base(app.mirrorPath(url)) {
+		t.Errorf("cache entries = %+v", entries)
+	}
+	testGit(t, app.mirrorPath(url), "rev-parse", "HEAD")
+}
+
+func TestCachedMirrorFailedClone(t *testing.T) {
+	app := testSourceApp(t)
+	url := "file://" + path.Join(t.TempDir(), "nosuch.git")
+	if _, err := app.cachedMirror(url); err == nil {
+		t.Fatal("cloning a missing repository should fail")
+	}
+	fis, _ := ioutil.ReadDir(path.Join(app.cacheDir, "git"))
+	if len(fis) != 0 {
+		t.Errorf("the failed clone left %s behind", fis[0].Name())
+	}
+}
+
+func TestCachePruneLock(t *testing.T) {
+	cacheDir := t.TempDir()
+	user := testSourceApp(t)
+	user.cacheDir = cacheDir
+	if err := user.useCache(); err != nil {
+		t.Fatal(err)
+	}
+	pruner := testSourceApp(t)
+	pruner.cacheDir = cacheDir
+	if err := pruner.lockCache(); err == nil {
+		t.Fatal("prune should fail while the cache is used")
+	}
+	user.cacheLock.Close()
+	if err := pruner.lockCache(); err != nil {
+		t.Fatalf("prune should lock an unused cache: %s", err)
+	}
+}
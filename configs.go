@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/ungerik/go-dry"
+)
+
+var configsCommand = cli.Command{
+	Name:  "configs",
+	Usage: "manage the vim configs shipped with this tool",
+	Subcommands: []cli.Command{
+		{
+			Name:    "list",
+			Usage:   "list the bundled and user vim configs",
+			Aliases: []string{"ls"},
+			Action: func(c *cli.Context) {
+				app := getApp(c)
+				if app.jsonOutput {
+					for _, name := range app.configNames() {
+						app.event("config", eventFields{"name": name, "status": app.configStatus(name)})
+					}
+					return
+				}
+				fmt.Println("List vim configs:")
+				for _, name := range app.configNames() {
+					fmt.Printf("  %-24s %s\n", name, strings.Join(app.configStatus(name), ", "))
+				}
+			},
+		},
+		{
+			Name:      "enable",
+			Usage:     "enable vim config(s), a missing bundled config is restored",
+			ArgsUsage: "<name> [<name> ...]",
+			Action: func(c *cli.Context) {
+				app, done := beginUpdate(c)
+				defer done()
+				for _, name := range configArgs(app, c) {
+					app.setConfigDisabled(name, false)
+					configPath := path.Join(app.configDir, name)
+					if asset, ok := bundledConfig(name); ok && !dry.FileExists(configPath) {
+						saveConfig(configPath, asset, false, false)
+					}
+					app.success("%s is enabled", name)
+				}
+				app.info("run '%s setup' to regenerate .vimrc", app.cmdName)
+			},
+		},
+		{
+			Name:      "disable",
+			Usage:     "disable vim config(s), they are kept but never sourced or restored",
+			ArgsUsage: "<name> [<name> ...]",
+			Action: func(c *cli.Context) {
+				app, done := beginUpdate(c)
+				defer done()
+				for _, name := range configArgs(app, c) {
+					app.setConfigDisabled(name, true)
+					app.success("%s is disabled", name)
+				}
+				app.info("run '%s setup' to regenerate .vimrc", app.cmdName)
+			},
+		},
+		{
+			Name:      "resolve",
+			Usage:     "mark the conflicting upgrade of bundled config(s) as merged by hand",
+			ArgsUsage: "<name> [<name> ...]",
+			Action: func(c *cli.Context) {
+				app, done := beginUpdate(c)
+				defer done()
+				for _, name := range configArgs(app, c) {
+					if err := app.resolveBundledConfig(name); err != nil {
+						app.err("%s", err)
+						app.fail("config", name, name, err)
+						continue
+					}
+					app.success("%s is resolved", name)
+				}
+			},
+		},
+		{
+			Name:      "which",
+			Usage:     "explain which config layer provides a vim config",
+			ArgsUsage: "<name>",
+			Action: func(c *cli.Context) {
+				app := getApp(c)
+				resolved := app.resolveConfigs()
+				for _, name := range configArgs(app, c) {
+					config := resolved[name]
+					if app.jsonOutput {
+						fields := eventFields{"name": name, "found": config != nil}
+						if config != nil {
+							fields["history"], fields["path"], fields["layer"] = config.history, config.path, config.layer
+						}
+						app.event("config.layers", fields)
+						continue
+					}
+					if config == nil {
+						fmt.Printf("%s: not found in any layer\n", name)
+						continue
+					}
+					fmt.Printf("%s:\n", name)
+					for _, line := range config.history {
+						fmt.Println("  " + line)
+					}
+					if config.path != "" {
+						fmt.Printf("  => %s (%s layer)\n", config.path, config.layer)
+					} else {
+						fmt.Println("  => not sourced")
+					}
+				}
+			},
+		},
+		{
+			Name:      "show",
+			Usage:     "print the bundled version of a vim config, or the user one",
+			ArgsUsage: "<name>",
+			Action: func(c *cli.Context) {
+				app := getApp(c)
+				for _, name := range configArgs(app, c) {
+					data, ok := bundledConfig(name)
+					if !ok {
+						var err error
+						if data, err = dry.FileGetBytes(path.Join(app.configDir, name)); err != nil {
+							app.fatal("no such vim config: %s", name)
+						}
+					}
+					if app.jsonOutput {
+						app.event("config.content", eventFields{"name": name, "bundled": ok, "content": string(data)})
+						continue
+					}
+					os.Stdout.Write(data)
+				}
+			},
+		},
+		{
+			Name:      "diff",
+			Usage:     "compare a vim config with its bundled version",
+			ArgsUsage: "<name>",
+			Action: func(c *cli.Context) {
+				app := getApp(c)
+				for _, name := range configArgs(app, c) {
+					asset, ok := bundledConfig(name)
+					if !ok {
+						app.fatal("%s is not a bundled vim config", name)
+					}
+					configPath := path.Join(app.configDir, name)
+					data, err := dry.FileGetBytes(configPath)
+					if err != nil {
+						app.fatal("%s is not installed", name)
+					}
+					diff := unifiedDiff("bundled/"+name, configPath, splitLines(asset), splitLines(data))
+					if app.jsonOutput {
+						app.event("config.diff", eventFields{"name": name, "path": configPath, "diff": diff})
+						continue
+					}
+					fmt.Print(diff)
+				}
+			},
+		},
+	},
+}
+
+// configArgs returns the config names given in the command line, the suffix
+// '.vimrc' can be omitted
+func configArgs(app *_appContext, c *cli.Context) []string {
+	if len(c.Args()) == 0 {
+		app.fatal("missing vim config name")
+	}
+	names := []string{}
+	for _, arg := range c.Args() {
+		name := path.Base(arg)
+		if !strings.HasSuffix(name, ".vimrc") {
+			name += ".vimrc"
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// bundledConfig returns the vim config embedded by go-bindata
+func bundledConfig(name string) ([]byte, bool) {
+	data, err := Asset("vim-configs/" + name)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func bundledConfigNames() []string {
+	names := []string{}
+	for _, asset := range AssetNames() {
+		if strings.HasPrefix(asset, "vim-configs/") {
+			names = append(names, path.Base(asset))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// configNames returns the names of bundled vim configs and the ones found in
+// the config layers
+func (app *_appContext) configNames() []string {
+	names := bundledConfigNames()
+	seen := make(map[string]bool)
+	for _, name := range names {
+		seen[name] = true
+	}
+	for name := range app.resolveConfigs() {
+		if !seen[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (app *_appContext) configStatus(name string) []string {
+	status := []string{}
+	asset, bundled := bundledConfig(name)
+	if bundled {
+		status = append(status, "bundled")
+	}
+	resolved := app.resolveConfigs()[name]
+	if resolved == nil || resolved.path == "" {
+		status = append(status, "disabled")
+	} else {
+		status = append(status, "enabled", resolved.layer+" layer")
+	}
+	data, err := dry.FileGetBytes(path.Join(app.configDir, name))
+	if err != nil {
+		status = append(status, "not installed")
+	} else if bundled && !bytes.Equal(data, asset) {
+		status = append(status, "modified")
+	}
+	if state := app.configState(name); state != nil && state.Pending != "" {
+		status = append(status, "upgrade conflicts")
+	}
+	return status
+}
+
+func (app *_appContext) isConfigDisabled(name string) bool {
+	for _, disabled := range app.states.DisabledConfigs {
+		if disabled == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (app *_appContext) setConfigDisabled(name string, disabled bool) {
+	configs := []string{}
+	for _, config := range app.states.DisabledConfigs {
+		if config != name {
+			configs = append(configs, config)
+		}
+	}
+	if disabled {
+		configs = append(configs, name)
+		sort.Strings(configs)
+	}
+	app.states.DisabledConfigs = configs
+}
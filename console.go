@@ -2,16 +2,50 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"reflect"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/fatih/color"
 )
 
+// logLevel is the verbosity chosen by -q, -v and -vv/--debug
+type logLevel int
+
+const (
+	_LEVEL_QUIET logLevel = iota
+	_LEVEL_NORMAL
+	_LEVEL_VERBOSE
+	_LEVEL_DEBUG
+)
+
+// logger prints the messages of the commands above its level, as colored text
+// or as json events, and records all of them in the log file
+type logger struct {
+	mu          sync.Mutex
+	progress    *progress
+	level       logLevel
+	showOutput  bool
+	jsonOutput  bool
+	logFile     *os.File
+	command     string
+	startedAt   time.Time
+	eventCounts map[string]int
+	failures    []failure
+	// gitErr is the first error git reported to the current command
+	gitErr string
+}
+
+func newLogger(level logLevel) *logger {
+	return &logger{level: level}
+}
+
 func prehandleArgs(a ...interface{}) (string, []interface{}, bool) {
 	if len(a) == 0 {
 		return "", nil, false
@@ -33,118 +67,114 @@ func prehandleArgs(a ...interface{}) (string, []interface{}, bool) {
 		format += " %+v"
 		nargs++
 	}
-	return format, args, true
+
+	format = redactSecrets(format)
+	redacted := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			redacted[i] = redactSecrets(v)
+		case error:
+			redacted[i] = redactSecrets(v.Error())
+		default:
+			redacted[i] = arg
+		}
+	}
+	return format, redacted, true
 
 }
 
-func (app *_appContext) println(a ...interface{}) {
-	if app.verboseFlag {
+// log records a message and prints it when the level allows it, the tag and
+// the colors only apply to the text output
+func (l *logger) log(level logLevel, name string, w io.Writer, tag string, colorize func(...interface{}) string, a ...interface{}) {
+	format, args, ok := prehandleArgs(a...)
+	if !ok {
 		return
 	}
-	format, args, _ := prehandleArgs(a...)
-	fmt.Fprintf(os.Stdout, format+"\n", args...)
-}
-
-func (app *_appContext) printf(a ...interface{}) {
-	if app.verboseFlag {
+	str := fmt.Sprintf(format, args...)
+	shown := l.level >= level
+	l.emit(name, "log", str, nil, shown)
+	if !shown || l.jsonOutput {
 		return
 	}
-	if format, args, ok := prehandleArgs(a...); ok {
-		fmt.Fprintf(os.Stdout, format, args...)
+	if colorize != nil {
+		str = colorize(str)
 	}
+	if tag != "" {
+		str = tag + " " + str
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.clearLine()
+	fmt.Fprintln(w, str)
 }
 
+// println prints a plain message at verbose level
+func (l *logger) println(a ...interface{}) {
+	l.log(_LEVEL_VERBOSE, "verbose", os.Stdout, "", nil, a...)
+}
+
+// codePosition returns the file@line of the caller of a printer
 func codePosition() string {
 	_, file, line, ok := runtime.Caller(2)
-	if ok {
-		p := strings.Index(file, "fulcrumos.com")
-		if p >= 0 {
-			file = file[p:]
-		} else {
-			file = path.Base(file)
-		}
-		return file + "@" + strconv.Itoa(line)
-	} else {
+	if !ok {
 		return ""
 	}
+	return path.Base(file) + "@" + strconv.Itoa(line)
 }
 
-var _ERROR_MSG = color.New(color.FgHiWhite, color.BgRed, color.Bold).SprintFunc()("ERROR:")
+var _ERROR_MSG = color.New(color.FgHiWhite, color.BgRed, color.Bold).SprintFunc()
 var _error = color.New(color.FgRed).SprintFunc()
 
-func (app *_appContext) err(a ...interface{}) {
-	if app.verboseFlag {
-		return
-	}
-	if format, args, ok := prehandleArgs(a...); ok {
-		str := fmt.Sprintf(" "+format+" ", args...)
-		fmt.Fprintf(os.Stderr, _ERROR_MSG+" "+_error(str)+"\n")
-	}
+func (l *logger) err(a ...interface{}) {
+	l.log(_LEVEL_QUIET, "error", os.Stderr, _ERROR_MSG("ERROR:"), func(s ...interface{}) string {
+		return _error(" " + fmt.Sprint(s...) + " ")
+	}, a...)
 }
 
-var _WARNING_MSG = color.New(color.FgBlack, color.BgYellow).SprintFunc()("WARNING:")
+var _WARNING_MSG = color.New(color.FgBlack, color.BgYellow).SprintFunc()
 var _warning = color.New(color.FgHiYellow, color.Bold, color.Underline).SprintFunc()
 
-func (app *_appContext) warn(a ...interface{}) {
-	if app.verboseFlag {
-		return
-	}
-	if format, args, ok := prehandleArgs(a...); ok {
-		str := fmt.Sprintf(format, args...)
-		fmt.Fprintf(os.Stdout, _WARNING_MSG+" "+_warning(str)+"\n")
-	}
+func (l *logger) warn(a ...interface{}) {
+	l.log(_LEVEL_NORMAL, "warning", os.Stdout, _WARNING_MSG("WARNING:"), _warning, a...)
 }
 
-func (app *_appContext) warning(a ...interface{}) {
-	app.warn(a...)
+func (l *logger) warning(a ...interface{}) {
+	l.warn(a...)
 }
 
-var _SUCCESS_MSG = color.New(color.FgWhite, color.BgGreen).SprintFunc()("SUCCESS:")
+var _SUCCESS_MSG = color.New(color.FgWhite, color.BgGreen).SprintFunc()
 var _success = color.New(color.FgHiGreen, color.Bold).SprintFunc()
 
-func (app *_appContext) success(a ...interface{}) {
-	if app.verboseFlag {
-		return
-	}
-	if format, args, ok := prehandleArgs(a...); ok {
-		str := fmt.Sprintf(format, args...)
-		fmt.Fprintf(os.Stdout, _SUCCESS_MSG+" "+_success(str)+"\n")
-	}
+func (l *logger) success(a ...interface{}) {
+	l.log(_LEVEL_NORMAL, "success", os.Stdout, _SUCCESS_MSG("SUCCESS:"), _success, a...)
 }
 
-var _FATAL_MSG = color.New(color.FgHiYellow, color.BgRed, color.Bold).SprintFunc()("FATAL:")
+var _FATAL_MSG = color.New(color.FgHiYellow, color.BgRed, color.Bold).SprintFunc()
 var _fatal = color.New(color.FgHiRed, color.Bold).SprintFunc()
 
-func (app *_appContext) fatal(a ...interface{}) {
-	if format, args, ok := prehandleArgs(a...); ok {
-		str := fmt.Sprintf(format, args...)
-		fmt.Fprintf(os.Stderr, _FATAL_MSG+" "+_fatal(str)+"\n")
-		os.Exit(-1)
-	}
+func (l *logger) fatal(a ...interface{}) {
+	l.log(_LEVEL_QUIET, "fatal", os.Stderr, _FATAL_MSG("FATAL:"), _fatal, a...)
+	l.exit(_EXIT_FAILED)
 }
 
-var _DEBUG_MSG = color.New(color.FgHiCyan, color.Bold).SprintFunc()("DBG")
+var _DEBUG_MSG = color.New(color.FgHiCyan, color.Bold).SprintFunc()
 var _debug = color.New(color.FgCyan).SprintFunc()
 
-func (app *_appContext) debug(a ...interface{}) {
-	if app.verboseFlag || !app.enableDebug {
-		return
-	}
-	if format, args, ok := prehandleArgs(a...); ok {
-		str := fmt.Sprintf(format, args...)
-		fmt.Fprintf(os.Stdout, _DEBUG_MSG+"("+codePosition()+"): "+_debug(str)+"\n")
-	}
+func (l *logger) debug(a ...interface{}) {
+	l.log(_LEVEL_DEBUG, "debug", os.Stdout, _DEBUG_MSG("DBG")+"("+codePosition()+"):", _debug, a...)
 }
 
-var _INFO_MSG = color.New(color.FgGreen, color.Bold).SprintFunc()("INFO:")
+var _INFO_MSG = color.New(color.FgGreen, color.Bold).SprintFunc()
 var _info = color.New(color.FgGreen).SprintFunc()
 
-func (app *_appContext) info(a ...interface{}) {
-	if app.verboseFlag {
-		return
-	}
-	if format, args, ok := prehandleArgs(a...); ok {
-		str := fmt.Sprintf(format, args...)
-		fmt.Fprintf(os.Stdout, _INFO_MSG+" "+_info(str)+"\n")
-	}
+func (l *logger) info(a ...interface{}) {
+	l.log(_LEVEL_NORMAL, "info", os.Stdout, _INFO_MSG("INFO:"), _info, a...)
+}
+
+var _VERBOSE_MSG = color.New(color.FgHiBlack).SprintFunc()
+
+// verbose prints the details only wanted with -v
+func (l *logger) verbose(a ...interface{}) {
+	l.log(_LEVEL_VERBOSE, "verbose", os.Stdout, "", _VERBOSE_MSG, a...)
 }
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// diffOp is one line of a line based diff: ' ' kept, '-' removed, '+' added
+type diffOp struct {
+	kind byte
+	line string
+}
+
+func splitLines(data []byte) []string {
+	s := string(data)
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func joinLines(lines []string) []byte {
+	if len(lines) == 0 {
+		return []byte{}
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// diffLines finds the longest common subsequence of a and b, vim configs are
+// small enough for the quadratic table
+func diffLines(a, b []string) []diffOp {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ops := []diffOp{}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		} else {
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// unifiedDiff formats the diff of a and b like 'diff -u', it's empty if they
+// are the same
+func unifiedDiff(nameA, nameB string, a, b []string) string {
+	const context = 3
+	ops := diffLines(a, b)
+	out := bytes.NewBuffer([]byte{})
+
+	for start := 0; start < len(ops); {
+		// find the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		if out.Len() == 0 {
+			fmt.Fprintf(out, "--- %s\n+++ %s\n", nameA, nameB)
+		}
+
+		begin := start - context
+		if begin < 0 {
+			begin = 0
+		}
+		// extend the hunk while the changes are close to each other
+		end, kept := start, 0
+		for end < len(ops) && kept <= 2*context {
+			if ops[end].kind == ' ' {
+				kept++
+			} else {
+				kept = 0
+			}
+			end++
+		}
+		if kept > context {
+			end -= kept - context
+		}
+
+		lineA, lineB := 1, 1
+		for _, op := range ops[:begin] {
+			if op.kind != '+' {
+				lineA++
+			}
+			if op.kind != '-' {
+				lineB++
+			}
+		}
+		countA, countB := 0, 0
+		for _, op := range ops[begin:end] {
+			if op.kind != '+' {
+				countA++
+			}
+			if op.kind != '-' {
+				countB++
+			}
+		}
+		// an empty range starts at the line before it, like 'diff -u'
+		if countA == 0 {
+			lineA--
+		}
+		if countB == 0 {
+			lineB--
+		}
+		fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", lineA, countA, lineB, countB)
+		for _, op := range ops[begin:end] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			out.WriteByte('\n')
+		}
+		start = end
+	}
+	return out.String()
+}
+
+// diffHunk replaces base[start:end] with lines
+type diffHunk struct {
+	start, end int
+	lines      []string
+}
+
+func diffHunks(base, other []string) []diffHunk {
+	hunks := []diffHunk{}
+	var hunk *diffHunk
+	i := 0
+	for _, op := range diffLines(base, other) {
+		if op.kind == ' ' {
+			if hunk != nil {
+				hunks = append(hunks, *hunk)
+				hunk = nil
+			}
+			i++
+			continue
+		}
+		if hunk == nil {
+			hunk = &diffHunk{start: i, end: i}
+		}
+		if op.kind == '-' {
+			i++
+			hunk.end = i
+		} else {
+			hunk.lines = append(hunk.lines, op.line)
+		}
+	}
+	if hunk != nil {
+		hunks = append(hunks, *hunk)
+	}
+	return hunks
+}
+
+// applyHunks returns base[start:end] with the hunks applied
+func applyHunks(base []string, start, end int, hunks []diffHunk) []string {
+	lines := []string{}
+	for _, h := range hunks {
+		lines = append(lines, base[start:h.start]...)
+		lines = append(lines, h.lines...)
+		start = h.end
+	}
+	return append(lines, base[start:end]...)
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeLines merges the changes from base to mine and from base to theirs,
+// it returns the number of conflicts, the regions changed on both sides
+// differently, those are resolved with mine
+func mergeLines(base, mine, theirs []string) ([]string, int) {
+	hunksA := diffHunks(base, mine)
+	hunksB := diffHunks(base, theirs)
+	merged := []string{}
+	conflicts := 0
+
+	pos, i, j := 0, 0, 0
+	for i < len(hunksA) || j < len(hunksB) {
+		// start a cluster with the first hunk, then take in the hunks which
+		// overlap or touch it from both sides
+		var start, end int
+		if j == len(hunksB) || (i < len(hunksA) && hunksA[i].start <= hunksB[j].start) {
+			start, end = hunksA[i].start, hunksA[i].end
+		} else {
+			start, end = hunksB[j].start, hunksB[j].end
+		}
+		ci, cj := i, j
+		for {
+			if ci < len(hunksA) && hunksA[ci].start <= end {
+				if hunksA[ci].end > end {
+					end = hunksA[ci].end
+				}
+				ci++
+			} else if cj < len(hunksB) && hunksB[cj].start <= end {
+				if hunksB[cj].end > end {
+					end = hunksB[cj].end
+				}
+				cj++
+			} else {
+				break
+			}
+		}
+
+		merged = append(merged, base[pos:start]...)
+		a := applyHunks(base, start, end, hunksA[i:ci])
+		b := applyHunks(base, start, end, hunksB[j:cj])
+		if ci == i {
+			merged = append(merged, b...)
+		} else if cj == j || equalLines(a, b) {
+			merged = append(merged, a...)
+		} else {
+			merged = append(merged, a...)
+			conflicts++
+		}
+		pos, i, j = end, ci, cj
+	}
+	return append(merged, base[pos:]...), conflicts
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testLines splits the words of s into lines, none for an empty file
+func testLines(s string) []string {
+	return strings.Fields(s)
+}
+
+func TestMergeLines(t *testing.T) {
+	for _, tc := range []struct {
+		desc               string
+		base, mine, theirs string
+		merged             string
+		conflicts          int
+	}{
+		{"unchanged", "a b c", "a b c", "a b c", "a b c", 0},
+		{"clean merge", "a b c d e f g h", "a B c d e f g h x", "a b c d e F g h", "a B c d e F g h x", 0},
+		{"theirs only", "a b c", "a b c", "a b C d", "a b C d", 0},
+		{"mine only", "a b c", "a c", "a b c", "a c", 0},
+		{"same change on both sides", "a b c", "a B c", "a B c", "a B c", 0},
+		{"adjacent hunks", "a b c d", "a B c d", "a b C d", "a B c d", 1},
+		{"overlapping hunks", "a b c d e", "a X d e", "a b Y e", "a X d e", 1},
+		{"hunks one line apart", "a b c d", "a B c d", "a b c D", "a B c D", 0},
+		{"insertions at start and end", "a b c", "x a b c", "a b c y", "x a b c y", 0},
+		{"insertions at the start on both sides", "a b", "x a b", "y a b", "x a b", 1},
+		{"insertions at the end on both sides", "a b", "a b x", "a b y", "a b x", 1},
+		{"deletion versus edit", "a b c", "a c", "a B c", "a c", 1},
+		{"edit versus deletion", "a b c", "a B c", "a c", "a B c", 1},
+		{"deletion and a distant edit", "a b c d e", "a c d e", "a b c d E", "a c d E", 0},
+		{"empty base", "", "a", "b", "a", 1},
+		{"empty base, mine empty", "", "", "x y", "x y", 0},
+		{"everything deleted on one side", "a b", "", "a b", "", 0},
+	} {
+		merged, conflicts := mergeLines(testLines(tc.base), testLines(tc.mine), testLines(tc.theirs))
+		if strings.Join(merged, " ") != tc.merged || conflicts != tc.conflicts {
+			t.Errorf("%s: mergeLines = %q, %d conflicts, want %q, %d", tc.desc, strings.Join(merged, " "), conflicts, tc.merged, tc.conflicts)
+		}
+	}
+}
+
+func TestDiffHunks(t *testing.T) {
+	for _, tc := range []struct {
+		base, other string
+		hunks       string
+	}{
+		{"a b c", "a b c", ""},
+		{"a b c d", "a X c d e", "1-2:X 4-4:e"},
+		{"a b c", "a c", "1-2:"},
+		{"a b", "x a b", "0-0:x"},
+		{"", "x y", "0-0:x,y"},
+		{"a b c", "", "0-3:"},
+	} {
+		hunks := []string{}
+		for _, h := range diffHunks(testLines(tc.base), testLines(tc.other)) {
+			hunks = append(hunks, fmt.Sprintf("%d-%d:%s", h.start, h.end, strings.Join(h.lines, ",")))
+		}
+		if strings.Join(hunks, " ") != tc.hunks {
+			t.Errorf("diffHunks(%q, %q) = %q, want %q", tc.base, tc.other, strings.Join(hunks, " "), tc.hunks)
+		}
+	}
+}
+
+// testNumbers returns the lines "1" to "n", with the lines of changes
+// replaced
+func testNumbers(n int, changes map[int]string) []string {
+	lines := []string{}
+	for i := 1; i <= n; i++ {
+		if line, ok := changes[i]; ok {
+			if line != "" {
+				lines = append(lines, line)
+			}
+			continue
+		}
+		lines = append(lines, fmt.Sprint(i))
+	}
+	return lines
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	for _, tc := range []struct {
+		desc string
+		a, b []string
+		diff string
+	}{
+		{"same", testLines("a b"), testLines("a b"), ""},
+		{"middle line", testNumbers(10, nil), testNumbers(10, map[int]string{5: "X"}),
+			"@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+X\n 6\n 7\n 8\n"},
+		{"insertion at the start", testLines("a b"), testLines("x a b"),
+			"@@ -1,2 +1,3 @@\n+x\n a\n b\n"},
+		{"deletion at the end", testLines("a b c"), testLines("a b"),
+			"@@ -1,3 +1,2 @@\n a\n b\n-c\n"},
+		{"empty a", testLines(""), testLines("x"),
+			"@@ -0,0 +1,1 @@\n+x\n"},
+		{"empty b", testLines("x y"), testLines(""),
+			"@@ -1,2 +0,0 @@\n-x\n-y\n"},
+		{"distant changes", testNumbers(20, nil), testNumbers(20, map[int]string{2: "B", 18: "R"}),
+			"@@ -1,5 +1,5 @@\n 1\n-2\n+B\n 3\n 4\n 5\n@@ -15,6 +15,6 @@\n 15\n 16\n 17\n-18\n+R\n 19\n 20\n"},
+		{"close changes share a hunk", testNumbers(12, nil), testNumbers(12, map[int]string{3: "C", 8: ""}),
+			"@@ -1,11 +1,10 @@\n 1\n 2\n-3\n+C\n 4\n 5\n 6\n 7\n-8\n 9\n 10\n 11\n"},
+	} {
+		want := tc.diff
+		if want != "" {
+			want = "--- a\n+++ b\n" + want
+		}
+		if diff := unifiedDiff("a", "b", tc.a, tc.b); diff != want {
+			t.Errorf("%s: unifiedDiff =\n%s\nwant\n%s", tc.desc, diff, want)
+		}
+	}
+}
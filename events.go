@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+)
+
+const (
+	_OUTPUT_TEXT = "text"
+	_OUTPUT_JSON = "json"
+)
+
+// eventFields are the fields of a structured event besides time, level and
+// event
+type eventFields map[string]interface{}
+
+// openEvents sets up '--output' and '--log-file': in json mode every message
+// and event is a json line on stdout, the log file gets all of them whatever
+// the output and the verbosity
+func (l *logger) openEvents(c *cli.Context) {
+	switch output := c.GlobalString("output"); output {
+	case "", _OUTPUT_TEXT:
+	case _OUTPUT_JSON:
+		l.jsonOutput = true
+	default:
+		l.fatal("unknown output %s, use %s or %s", output, _OUTPUT_TEXT, _OUTPUT_JSON)
+	}
+	if l.jsonOutput || !isatty.IsTerminal(os.Stdout.Fd()) {
+		color.NoColor = true
+	}
+
+	l.command = c.Command.Name
+	l.startedAt = time.Now()
+	l.eventCounts = make(map[string]int)
+	if logPath := c.GlobalString("log-file"); logPath != "" {
+		f, err := os.OpenFile(expandHome(logPath), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			l.fatal("unable to open log file %s (error: %s)", logPath, err)
+		}
+		l.logFile = f
+	}
+}
+
+// event records a structured event, e.g. a plugin cloned or a script ran
+func (l *logger) event(name string, fields eventFields) {
+	l.emit("info", name, "", fields, true)
+}
+
+func (l *logger) emit(level, name, msg string, fields eventFields, shown bool) {
+	if l.eventCounts == nil {
+		// before openEvents, e.g. an invalid settings.yml
+		return
+	}
+	// the fields often carry urls and git errors, which may carry a token
+	record := eventFields{}
+	for k, v := range fields {
+		record[k] = redactField(v)
+	}
+	record["time"] = time.Now().Format(time.RFC3339)
+	record["level"] = level
+	record["event"] = name
+	if msg != "" {
+		record["msg"] = redactSecrets(msg)
+	}
+	if name == "log" {
+		l.eventCounts[level]++
+	} else {
+		l.eventCounts[name]++
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+	if l.logFile != nil {
+		l.logFile.Write(data)
+	}
+	if l.jsonOutput && shown {
+		os.Stdout.Write(data)
+	}
+}
+
+func redactField(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return redactSecrets(v)
+	case error:
+		return redactSecrets(v.Error())
+	case []string:
+		redacted := make([]string, len(v))
+		for i, s := range v {
+			redacted[i] = redactSecrets(s)
+		}
+		return redacted
+	}
+	return v
+}
+
+// childOutput returns where the output of git and scripts is streamed with
+// --show-output, nothing otherwise; stdout belongs to the events in json mode
+func (l *logger) childOutput() (io.Writer, io.Writer) {
+	if !l.showOutput {
+		return nil, nil
+	}
+	if l.jsonOutput {
+		return os.Stderr, os.Stderr
+	}
+	return os.Stdout, os.Stderr
+}
+
+// closeEvents emits the summary of the command and closes the log file
+func (l *logger) closeEvents(exitCode int) {
+	if l.eventCounts == nil {
+		return
+	}
+	counts := l.eventCounts
+	l.emit("info", "summary", "", eventFields{
+		"command":     l.command,
+		"exit_code":   exitCode,
+		"duration_ms": time.Since(l.startedAt).Nanoseconds() / int64(time.Millisecond),
+		"errors":      counts["error"],
+		"warnings":    counts["warning"],
+		"failures":    len(l.failures),
+		"events":      counts,
+	}, true)
+	l.eventCounts = nil
+	if l.logFile != nil {
+		l.logFile.Close()
+		l.logFile = nil
+	}
+}
+
+// exit ends the command with the report of the failures and the summary
+// event, a command which went through exits with _EXIT_PARTIAL if something
+// failed
+func (l *logger) exit(code int) {
+	if p := l.progress; p != nil {
+		p.depth = 0
+		p.finish()
+	}
+	if partial := l.reportFailures(); code == 0 {
+		code = partial
+	}
+	l.closeEvents(code)
+	os.Exit(code)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	// _EXIT_FAILED is the exit code of a command which couldn't do its job,
+	// e.g. after a fatal error
+	_EXIT_FAILED = 1
+	// _EXIT_PARTIAL is the exit code of a command which went through, but
+	// some of the configs, plugins or scripts failed
+	_EXIT_PARTIAL = 2
+)
+
+// failure is a config, plugin or script which failed during the command, they
+// are reported together at the end
+type failure struct {
+	kind   string
+	name   string
+	config string
+	err    error
+}
+
+func (f failure) String() string {
+	s := f.kind + " " + f.name
+	if f.config != "" && f.config != f.name {
+		s += " (" + f.config + ")"
+	}
+	return s + ": " + redactSecrets(f.err.Error())
+}
+
+// fail records a failure, the same one is only recorded once
+func (l *logger) fail(kind, name, config string, err error) {
+	if l.failed(kind, name) {
+		return
+	}
+	l.failures = append(l.failures, failure{kind, name, config, err})
+	l.emit("error", "failure", "", eventFields{"kind": kind, "name": name, "config": config, "error": err}, true)
+}
+
+// failCommand records that the command itself failed, it exits with
+// _EXIT_FAILED after the report
+func (l *logger) failCommand(err error) {
+	l.fail("command", l.command, "", err)
+}
+
+// failed tells if a failure is recorded already
+func (l *logger) failed(kind, name string) bool {
+	for _, f := range l.failures {
+		if f.kind == kind && f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// reportFailures prints the failures of the command and returns the exit
+// code they lead to
+func (l *logger) reportFailures() int {
+	if len(l.failures) == 0 {
+		return 0
+	}
+	if !l.jsonOutput {
+		l.err("%d failure(s):", len(l.failures))
+		for _, f := range l.failures {
+			l.mu.Lock()
+			fmt.Fprintln(os.Stderr, "  "+f.String())
+			l.mu.Unlock()
+		}
+	}
+	if l.failed("command", l.command) {
+		return _EXIT_FAILED
+	}
+	return _EXIT_PARTIAL
+}
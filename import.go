@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/ungerik/go-dry"
+)
+
+var importCommand = cli.Command{
+	Name:      "import",
+	Usage:     "import plugins of vim-plug, Vundle, NeoBundle and existing bundle checkouts",
+	ArgsUsage: "[<vimrc> ...]",
+	Action: func(c *cli.Context) {
+		app, done := beginUpdate(c)
+		defer done()
+
+		oldVimrc := path.Join(app.configDir, "_old_config.vimrc")
+		vimrcs := c.Args()
+		if len(vimrcs) == 0 {
+			if !dry.FileExists(oldVimrc) {
+				if dry.FileExists(app.vimrcPath) && !isGeneratedVimrc(app.vimrcPath) {
+					app.fatal("%s is not saved yet, run '%s setup' first", app.vimrcPath, app.cmdName)
+				}
+			} else {
+				vimrcs = []string{oldVimrc}
+			}
+		}
+
+		configs, err := app.parseVimConfigs()
+		if err != nil {
+			app.fatal("unable to read vim configs (error: %s)", err)
+		}
+		// plugins required by the vim configs already, and the configs
+		required := make(map[string]string)
+		for _, config := range configs {
+			for _, plugin := range config.requires() {
+				key := pluginKey(newPluginSpec(plugin, "").url)
+				if required[key] == "" {
+					required[key] = config.name
+				}
+			}
+		}
+
+		for _, vimrc := range vimrcs {
+			plugins, stripped, err := parseManagedVimrc(vimrc)
+			if err != nil {
+				app.err("unable to read %s (error: %s)", vimrc, err)
+				app.fail("vimrc", vimrc, "", err)
+				continue
+			}
+			for _, p := range plugins {
+				app.importPlugin(p, path.Base(vimrc), required)
+			}
+			if vimrc == oldVimrc && len(plugins) > 0 {
+				app.info("strip plugin manager from %s", vimrc)
+				saveConfig(vimrc, stripped, true, true)
+			}
+		}
+
+		app.adoptBundles(required)
+	},
+}
+
+// importedPlugin is a plugin declared by another plugin manager
+type importedPlugin struct {
+	manager string
+	name    string
+	url     string
+	ref     string
+	build   string
+	line    int
+}
+
+var _PLUGIN_MANAGER_PATTERN = regexp.MustCompile("^\\s*(Plug|Plugin|Bundle|NeoBundle|NeoBundleLazy|NeoBundleFetch)\\s+['\"]([^'\"]+)['\"]\\s*(?:,\\s*(.*)|)$")
+var _PLUGIN_OPTION_PATTERN = regexp.MustCompile("['\"](\\w+)['\"]\\s*:\\s*['\"]([^'\"]*)['\"]")
+var _PLUGIN_MANAGER_BOOTSTRAP_PATTERN = regexp.MustCompile("^\\s*(?:call\\s+(?:plug|vundle|neobundle)#\\w+|NeoBundleCheck|set\\s+(?:rtp|runtimepath)\\s*\\+=.*(?i:vundle|neobundle))")
+
+// _PLUGIN_MANAGERS are not imported as plugins, pathogen is installed anyway
+var _PLUGIN_MANAGERS = []string{"vim-plug", "Vundle.vim", "vundle", "neobundle.vim", "vim-pathogen"}
+
+// parseManagedVimrc finds the plugins declared for vim-plug, Vundle and
+// NeoBundle in a vimrc, it also returns the vimrc with their bootstrap lines
+// commented out
+func parseManagedVimrc(vimrc string) ([]*importedPlugin, *bytes.Buffer, error) {
+	file, err := os.Open(vimrc)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	plugins := []*importedPlugin{}
+	stripped := bytes.NewBuffer([]byte{})
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		l := scanner.Text()
+		if ss := _PLUGIN_MANAGER_PATTERN.FindStringSubmatch(l); len(ss) > 0 {
+			stripped.WriteString("\" ")
+			if p := newImportedPlugin(ss[1], ss[2], ss[3]); p != nil {
+				p.line = lineNo
+				plugins = append(plugins, p)
+			}
+		} else if _PLUGIN_MANAGER_BOOTSTRAP_PATTERN.MatchString(l) {
+			stripped.WriteString("\" ")
+		}
+		stripped.WriteString(l)
+		stripped.WriteString("\n")
+	}
+	return plugins, stripped, scanner.Err()
+}
+
+func newImportedPlugin(manager, repo, options string) *importedPlugin {
+	for _, m := range _PLUGIN_MANAGERS {
+		if path.Base(repo) == m {
+			return nil
+		}
+	}
+
+	p := &importedPlugin{manager: manager, url: stripSecret(repo)}
+	switch {
+	case strings.HasPrefix(repo, "~"), strings.HasPrefix(repo, "."), strings.HasPrefix(repo, "/"):
+		// a local working directory, e.g. Plug '~/my-prototype-plugin',
+		// it's linked through its file:// url
+		if _, url := getPluginNameFromUrl(repo); url != "" {
+			p.url = url
+		}
+	case strings.Contains(repo, ":"):
+		// a url, left to the resolver
+	case !strings.Contains(repo, "/"):
+		// vim.org scripts mirrored by vim-scripts, the Vundle way
+		p.url = "github.com/vim-scripts/" + repo
+	case strings.Count(repo, "/") == 1:
+		p.url = "github.com/" + repo
+	}
+
+	opts := make(map[string]string)
+	for _, ss := range _PLUGIN_OPTION_PATTERN.FindAllStringSubmatch(options, -1) {
+		opts[ss[1]] = ss[2]
+	}
+	for _, key := range []string{"tag", "branch", "commit", "rev"} {
+		if opts[key] != "" {
+			p.ref = opts[key]
+			break
+		}
+	}
+	p.build = opts["do"]
+	if p.build == "" {
+		p.build = opts["build"]
+	}
+	if p.build == "" {
+		// NeoBundle takes a build command per platform
+		platform := "linux"
+		if runtime.GOOS == "darwin" {
+			platform = "mac"
+		}
+		if p.build = opts[platform]; p.build == "" {
+			p.build = opts["unix"]
+		}
+	}
+	if strings.HasPrefix(p.build, ":") {
+		p.build = "vim -E -s +" + shellQuote(strings.TrimPrefix(p.build, ":")) + " +qall"
+	}
+	return p
+}
+
+func isGeneratedVimrc(vimrc string) bool {
+	data, err := dry.FileGetBytes(vimrc)
+	if err != nil {
+		return false
+	}
+	return bytes.Contains(data, []byte("\" THIS FILE IS GENERATED BY "))
+}
+
+// importPlugin writes a vim config requiring the plugin, unless a vim config
+// requires it already, and adopts its checkout if it's in the bundle dir
+func (app *_appContext) importPlugin(p *importedPlugin, from string, required map[string]string) {
+	spec := newPluginSpec(p.url, "")
+	spec.ref = p.ref
+	key := p.name
+	if key == "" {
+		key = pluginKey(spec.url)
+	}
+	app.info("import %s from %s (%s)", key, from, p.manager)
+
+	configName, ok := required[key]
+	if !ok {
+		configName = key + ".vimrc"
+		required[key] = configName
+		directive := p.url
+		if p.ref != "" {
+			directive += "#" + p.ref
+		}
+		if p.line > 0 {
+			from = fmt.Sprintf("%s:%d", from, p.line)
+		}
+		config := bytes.NewBufferString(fmt.Sprintf("\" imported from %s by '%s import'\n", from, app.cmdName))
+		config.WriteString("\" @require: " + directive + "\n")
+		if p.build != "" {
+			config.WriteString("\"\n\" @run-script\n")
+			config.WriteString("\" cd \"$VIMDIR/bundle/" + key + "\"\n")
+			config.WriteString("\" " + p.build + "\n")
+			config.WriteString("\" @end-script\n")
+		}
+		configPath := path.Join(app.configDir, configName)
+		if dry.FileExists(configPath) {
+			app.warn("%s exists already, add '@require: %s' to it by hand", configName, directive)
+		} else if !saveConfig(configPath, config, false, false) {
+			app.err("unable to write %s", configPath)
+		}
+	} else {
+		app.info("%s is required by %s already", key, configName)
+	}
+
+	app.adoptBundle(key, configName)
+}
+
+// adoptBundles records the existing git checkouts in the bundle dir, so they
+// won't be cloned again
+func (app *_appContext) adoptBundles(required map[string]string) {
+	dirs, err := dry.ListDirDirectories(app.bundleDir)
+	if err != nil {
+		return
+	}
+	for _, name := range dirs {
+		if app.pluginState(name) != nil {
+			continue
+		}
+		installDir := path.Join(app.bundleDir, name)
+		url := stripSecret(gitOutput(installDir, "config", "--get", "remote.origin.url"))
+		if url == "" {
+			app.warn("skip %s, it's not a git checkout", installDir)
+			continue
+		}
+		p := &importedPlugin{manager: "bundle", name: name, url: url}
+		app.importPlugin(p, app.bundleDir, required)
+	}
+}
+
+func (app *_appContext) adoptBundle(name, configName string) {
+	installDir := path.Join(app.bundleDir, name)
+	if app.pluginState(name) != nil || !dry.FileIsDir(path.Join(installDir, ".git")) {
+		return
+	}
+	app.info("adopt the checkout of %s", name)
+	app.setPluginState(name, &pluginState{
+		URL:         stripSecret(gitOutput(installDir, "config", "--get", "remote.origin.url")),
+		Ref:         gitOutput(installDir, "rev-parse", "--abbrev-ref", "HEAD"),
+		Commit:      gitOutput(installDir, "rev-parse", "HEAD"),
+		InstalledAt: time.Now(),
+		Config:      configName,
+	})
+}
@@ -1,19 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
 	"path"
-	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/codegangsta/cli"
-	"github.com/fatih/color"
 	"github.com/ungerik/go-dry"
 )
 
@@ -21,13 +23,23 @@ var installCommand = cli.Command{
 	Name:    "install",
 	Usage:   "install vim plugin(s)",
 	Aliases: []string{"i"},
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "link",
+			Usage: "symlink local working directories instead of cloning them",
+		},
+	},
 	Action: func(c *cli.Context) {
 		if len(c.Args()) == 0 {
-			color.Yellow("Missing vim plugin")
-			return
+			getApp(c).fatal("missing vim plugin")
 		}
+		getApp(c).checkPrerequisites()
+		app, done := beginUpdate(c)
+		defer done()
 		for _, plugin := range c.Args() {
-			_app.installPlugin(plugin)
+			spec := newPluginSpec(plugin, "")
+			spec.link = spec.link || c.Bool("link")
+			app.installPlugin(spec)
 		}
 	},
 }
@@ -77,15 +89,28 @@ type searchResult struct {
 }
 
 func (app *_appContext) searchVimPlugin(keyword string, pageIndex int) (*searchResult, bool) {
+	if app.offline {
+		app.err("unable to search %s in offline mode", keyword)
+		return nil, false
+	}
 	queries := make(url.Values)
 	queries.Add("q", keyword)
 	queries.Add("page", strconv.Itoa(pageIndex))
-	resp, err := http.Get("http://vimawesome.com/?" + queries.Encode())
-	if err != nil {
-		return nil, false
-	}
-	_data, err := ioutil.ReadAll(resp.Body)
+	var _data []byte
+	err := app.retry("search "+keyword, nil, func() error {
+		resp, err := app.httpGet("http://vimawesome.com/?" + queries.Encode())
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return &httpStatusError{resp.Status, resp.StatusCode}
+		}
+		_data, err = ioutil.ReadAll(resp.Body)
+		return err
+	})
 	if err != nil {
+		app.debug("unable to search", keyword, err)
 		return nil, false
 	}
 	searchResult := searchResult{}
@@ -96,32 +121,66 @@ func (app *_appContext) searchVimPlugin(keyword string, pageIndex int) (*searchR
 	return &searchResult, true
 }
 
-var _GIT_HTTP_URL_PATTERN = regexp.MustCompile("(https?\\:\\/\\/|)(?:([^\\/]+\\.(?:com|org|io|net))\\/|)(.*)(\\.git|)")
-var _GIT_SSH_PATTERN = regexp.MustCompile("([^@]+)@([^\\:]+)\\:(.*)\\.git")
+// pluginSpec describes a plugin to install, it comes from a '@require'
+// directive, the plugins.yml manifest or the command line
+type pluginSpec struct {
+	url        string
+	ref        string
+	build      string
+	config     string
+	declaredIn string
+	enabled    bool
+	// link symlinks a local working directory instead of cloning it
+	link bool
+	// update pulls the plugin even if it's installed at the requested ref
+	update bool
+}
 
-func getPluginNameFromUrl(url string) (string, string) {
-	pluginName := ""
-	if ss := _GIT_HTTP_URL_PATTERN.FindStringSubmatch(url); len(ss) > 0 {
-		pluginName = path.Base(ss[3])
-		if ss[1] != "" {
-			url = ss[1]
-		} else {
-			url = "https://"
-		}
-		url += ss[2] + "/" + ss[3] + ss[4]
-	} else if ss := _GIT_SSH_PATTERN.FindStringSubmatch(url); len(ss) > 0 {
-		pluginName = path.Base(ss[3])
-	} else {
-		return "", ""
+// newPluginSpec parses a plugin written as '<url>[#<ref>]', config is the vim
+// config which requires it, or empty if it's installed by hand
+func newPluginSpec(plugin, config string) *pluginSpec {
+	spec := &pluginSpec{
+		url:        plugin,
+		config:     config,
+		declaredIn: config,
+		enabled:    true,
+	}
+	if p := strings.LastIndex(plugin, "#"); p > 0 {
+		spec.url, spec.ref = plugin[:p], plugin[p+1:]
 	}
-	return pluginName, url
+	// '@require: file:///...' is a working directory to link
+	spec.link = strings.HasPrefix(spec.url, "file://")
+	if spec.declaredIn == "" {
+		spec.declaredIn = "command line"
+	}
+	return spec
+}
+
+// name returns the bundle name of the plugin, or empty if it must be searched
+func (spec *pluginSpec) name() string {
+	name, _ := getPluginNameFromUrl(spec.url)
+	return name
 }
 
-func (app *_appContext) installPlugin(url string) error {
+// installPlugin clones or updates a plugin into the bundle dir, checks out the
+// requested ref and runs its build command
+func (app *_appContext) installPlugin(spec *pluginSpec) (err error) {
 	gitflag := false
 
-	var pluginName string
-	pluginName, url = getPluginNameFromUrl(url)
+	pluginName, url := getPluginNameFromUrl(spec.url)
+	if pluginName == "" {
+		url = spec.url
+	}
+	defer func() {
+		// a failed build is reported as the failure of its script
+		if err != nil && !app.failed("script", "plugins.yml:"+pluginName) {
+			name := pluginName
+			if name == "" {
+				name = spec.url
+			}
+			app.fail("plugin", name, spec.config, err)
+		}
+	}()
 
 	app.info("Install plugin:", pluginName)
 
@@ -167,56 +226,211 @@ func (app *_appContext) installPlugin(url string) error {
 			return errors.New("name error")
 		}
 	} else {
-		gitflag = true
+		gitflag = !isArchiveURL(url)
 	}
 
-	if app.getBoolState("plugin:" + pluginName) {
-		app.info("%s has been installed.", pluginName)
+	installDir := path.Join(app.bundleDir, pluginName)
+	p := app.startProgress(pluginName)
+	defer p.finish()
+
+	if spec.link {
+		return app.linkPlugin(spec, pluginName, url, installDir)
+	}
+	if state := app.pluginState(pluginName); state != nil && state.Linked {
+		app.info("%s is linked to %s, skip it", pluginName, strings.TrimPrefix(state.URL, "file://"))
 		return nil
 	}
+	if state := app.pluginState(pluginName); state != nil && !spec.update && (spec.ref == "" || spec.ref == state.Ref) {
+		app.verbose("%s has been installed.", pluginName)
+		return app.buildPlugin(spec, pluginName, installDir)
+	}
 
-	installDir := path.Join(app.bundleDir, pluginName)
-
+	event := "plugin.downloaded"
 	if gitflag {
-		var cmd *exec.Cmd
+		var err error
+		event = "plugin.cloned"
 		if dry.FileIsDir(path.Join(installDir, ".git")) {
+			event = "plugin.updated"
 			app.info("Updating", url)
-			cmd = exec.Command("git", "pull")
-			cmd.Dir = installDir
+			p.update("updating")
+			if spec.ref != "" {
+				err = app.gitFetch(installDir, url)
+			} else if app.offline {
+				if err = app.gitFetch(installDir, url); err == nil {
+					err = app.gitCommand(installDir, "merge", "--ff-only", "@{u}").Run()
+				}
+			} else {
+				err = app.retry("pull "+stripSecret(url), nil, func() error {
+					return app.gitError(app.gitRemoteCommand(url, installDir, "pull").Run())
+				})
+			}
 		} else {
 			app.info("Cloning", url)
+			p.update("cloning")
 			os.RemoveAll(installDir)
-			cmd = exec.Command("git", "clone", url, installDir)
-		}
-		cmd.Stdin = os.Stdin
-		if app.enableDebug {
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
+			err = app.gitClone(url, installDir)
 		}
 
-		if err := cmd.Run(); err != nil {
+		if err != nil {
 			// cannot access to the git
+			err = app.gitError(err)
 			app.err("Unable to sync:", url)
+			app.event("plugin.failed", eventFields{"plugin": pluginName, "url": stripSecret(url), "error": err})
 			return err
 		}
 
+		if spec.ref != "" {
+			if err := app.gitCommand(installDir, "checkout", "-q", spec.ref).Run(); err != nil {
+				app.err("Unable to checkout %s of %s", spec.ref, pluginName)
+				return app.gitError(err)
+			}
+			if gitOutput(installDir, "symbolic-ref", "-q", "HEAD") != "" {
+				// a branch, catch up with the remote one
+				app.gitCommand(installDir, "merge", "--ff-only", "@{u}").Run()
+			}
+		}
+
 		submoduleFile := path.Join(installDir, ".gitmodules")
 		if dry.FileExists(submoduleFile) {
-			cmd = exec.Command("git", "submodule", "update", "--init", "--recursive")
-			cmd.Dir = installDir
-			cmd.Stdin = os.Stdin
-			if app.enableDebug {
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
+			args := []string{"submodule", "update", "--init", "--recursive"}
+			if app.offline {
+				// --no-fetch still clones the missing submodules, they
+				// aren't mirrored in the cache
+				if missing := missingSubmodules(installDir); len(missing) > 0 {
+					app.err("Unable to update the submodules of %s", pluginName)
+					return fmt.Errorf("submodule %s: %s", strings.Join(missing, ", "), errOffline)
+				}
+				args = append(args, "--no-fetch")
 			}
-			if err := cmd.Run(); err != nil {
+			if err := app.gitRemoteCommand(url, installDir, args...).Run(); err != nil {
 				// cannot access to the git
-				return err
+				app.err("Unable to update the submodules of %s", pluginName)
+				return app.gitError(err)
 			}
 		}
-	} else {
+	} else if err := app.installArchive(url, installDir); err != nil {
+		app.err("Unable to download %s (error: %s)", url, err)
+		return err
+	}
+
+	state := &pluginState{
+		URL:         stripSecret(url),
+		Ref:         spec.ref,
+		InstalledAt: time.Now(),
+		Config:      spec.config,
+	}
+	if gitflag {
+		if state.Ref == "" {
+			state.Ref = gitOutput(installDir, "rev-parse", "--abbrev-ref", "HEAD")
+		}
+		state.Commit = gitOutput(installDir, "rev-parse", "HEAD")
+	}
+	app.setPluginState(pluginName, state)
+	app.event(event, eventFields{"plugin": pluginName, "url": state.URL, "ref": state.Ref, "commit": state.Commit})
+	return app.buildPlugin(spec, pluginName, installDir)
+}
+
+// linkPlugin symlinks a local working directory into the bundle dir, the
+// linked plugins are never pulled, updated or cleaned
+func (app *_appContext) linkPlugin(spec *pluginSpec, pluginName, url, installDir string) error {
+	if !strings.HasPrefix(url, "file://") {
+		app.err("unable to link %s, it's not a local directory", spec.url)
+		return errors.New("not a local directory")
+	}
+	src := strings.TrimPrefix(url, "file://")
+	if !dry.FileIsDir(src) {
+		app.err("unable to link %s, no such directory", src)
+		return errors.New("no such directory")
+	}
+
+	state := app.pluginState(pluginName)
+	if target, err := os.Readlink(installDir); err == nil {
+		if target == src && state != nil && state.Linked {
+			app.info("%s is linked to %s", pluginName, src)
+			return app.buildPlugin(spec, pluginName, installDir)
+		}
+		os.Remove(installDir)
+	} else if dry.FileExists(installDir) {
+		if !app.forceUpdate {
+			app.err("%s is installed already, use --force to replace it with a link", pluginName)
+			return errors.New("plugin exists")
+		}
+		os.RemoveAll(installDir)
+	}
+
+	app.info("Linking", src)
+	os.MkdirAll(app.bundleDir, 0755)
+	if err := os.Symlink(src, installDir); err != nil {
+		app.err("unable to link %s (error: %s)", src, err)
+		return err
 	}
+	app.setPluginState(pluginName, &pluginState{
+		URL:         url,
+		InstalledAt: time.Now(),
+		Config:      spec.config,
+		Linked:      true,
+	})
+	app.event("plugin.linked", eventFields{"plugin": pluginName, "url": url})
+	return app.buildPlugin(spec, pluginName, installDir)
+}
+
+// buildPlugin runs the build command of the plugin inside its directory, it's
+// tracked like the scripts of vim configs so it only runs again when changed
+func (app *_appContext) buildPlugin(spec *pluginSpec, pluginName, installDir string) error {
+	if spec.build == "" {
+		return nil
+	}
+	script := "cd " + shellQuote(installDir) + "\n" + spec.build + "\n"
+	return app.runScript(bytes.NewBufferString(script), "plugins.yml:"+pluginName, installDir)
+}
+
+// gitCommand prepares a git command running inside dir, its output is only
+// shown with --show-output, otherwise its progress feeds the current one
+func (app *_appContext) gitCommand(dir string, args ...string) *exec.Cmd {
+	stdout, stderr := app.childOutput()
+	if stderr == nil && app.progress != nil {
+		stderr = app.progress
+	}
+	if stderr != nil {
+		// git only reports its progress to a terminal
+		args = gitProgressArgs(args)
+	}
+	app.gitErr = ""
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdin = os.Stdin
+	cmd.Stdout, cmd.Stderr = stdout, &gitStderr{l: app.logger, next: stderr}
+	app.debug("run git %s in %s", strings.Join(args, " "), dir)
+	return cmd
+}
 
-	app.setState("plugin:"+pluginName, true)
-	return nil
+// missingSubmodules returns the submodules of a checkout which are not cloned
+// yet
+func missingSubmodules(dir string) []string {
+	missing := []string{}
+	cmd := exec.Command("git", "submodule", "status", "--recursive")
+	cmd.Dir = dir
+	out, _ := cmd.Output()
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Fields(line); strings.HasPrefix(line, "-") && len(fields) > 1 {
+			missing = append(missing, fields[1])
+		}
+	}
+	return missing
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "'\\''", -1) + "'"
+}
+
+// gitOutput runs a git command inside dir and returns its trimmed output, or
+// empty if it fails
+func gitOutput(dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ungerik/go-dry"
+)
+
+const _SYSTEM_CONFIG_DIR = "/etc/vim-plugin-setup/configs"
+
+// configLayer is a directory of vim configs, a config in a higher layer
+// replaces the one of the same name in the lower layers, and a marker
+// '<name>.disabled' masks it.
+// The embedded layer and the user layer share config dir: a bundled config
+// left unmodified there belongs to the embedded layer, so the system and
+// source layers can replace it, once it's edited it belongs to the user.
+type configLayer struct {
+	name string
+	dir  string
+}
+
+// resolvedConfig is the result of layering a vim config, path is empty if it's
+// masked, the history explains how it's resolved from the lowest layer
+type resolvedConfig struct {
+	name    string
+	path    string
+	layer   string
+	history []string
+}
+
+// configLayers returns the layers from the lowest to the highest
+func (app *_appContext) configLayers() []configLayer {
+	layers := []configLayer{
+		{name: "embedded", dir: app.configDir},
+		{name: "system", dir: app.systemConfigDir},
+	}
+	for _, source := range app.sourceNames() {
+		layers = append(layers, configLayer{name: "source " + source, dir: app.sourceConfigDir(source)})
+	}
+	return append(layers, configLayer{name: "user", dir: app.configDir})
+}
+
+// isBundledCopy tells whether a config in config dir is an unmodified copy of
+// the bundled version recorded for it
+func (app *_appContext) isBundledCopy(name string) bool {
+	state := app.configState(name)
+	if state == nil {
+		return false
+	}
+	data, err := ioutil.ReadFile(path.Join(app.configDir, name))
+	return err == nil && assetHash(data) == state.AssetHash
+}
+
+func (app *_appContext) resolveConfigs() map[string]*resolvedConfig {
+	resolved := make(map[string]*resolvedConfig)
+	get := func(name string) *resolvedConfig {
+		if resolved[name] == nil {
+			resolved[name] = &resolvedConfig{name: name}
+		}
+		return resolved[name]
+	}
+
+	for _, layer := range app.configLayers() {
+		fl, err := dry.ListDirFiles(layer.dir)
+		if err != nil {
+			continue
+		}
+		for _, f := range fl {
+			embedded := layer.name == "embedded"
+			if name := strings.TrimSuffix(f, ".disabled"); name != f && isVimConfigFile(name) {
+				if embedded {
+					// markers in config dir belong to the user
+					continue
+				}
+				config := get(name)
+				config.path, config.layer = "", layer.name
+				config.history = append(config.history, "masked by "+path.Join(layer.dir, f)+" ("+layer.name+" layer)")
+				continue
+			}
+			if !isVimConfigFile(f) {
+				continue
+			}
+			if layer.dir == app.configDir && embedded != app.isBundledCopy(f) {
+				continue
+			}
+			config := get(f)
+			line := "provided by " + path.Join(layer.dir, f) + " (" + layer.name + " layer)"
+			if config.path != "" {
+				line += ", replaces the one of " + config.layer + " layer"
+			}
+			config.path, config.layer = path.Join(layer.dir, f), layer.name
+			config.history = append(config.history, line)
+		}
+	}
+
+	for name, config := range resolved {
+		if app.isConfigDisabled(name) {
+			config.path = ""
+			config.history = append(config.history, "disabled by '"+app.cmdName+" configs disable'")
+		}
+	}
+	return resolved
+}
+
+// activeConfigs returns the resolved configs which are not masked, by name
+func (app *_appContext) activeConfigs() []*resolvedConfig {
+	resolved := app.resolveConfigs()
+	names := []string{}
+	for name, config := range resolved {
+		if config.path != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	configs := []*resolvedConfig{}
+	for _, name := range names {
+		configs = append(configs, resolved[name])
+	}
+	return configs
+}
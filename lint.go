@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/ungerik/go-dry"
+)
+
+// _SOURCE_PATTERN matches ':so[urce] <file>' in a vim config
+var _SOURCE_PATTERN = regexp.MustCompile("^\\s*:?so(?:u|ur|urc|urce)?!?\\s+(\\S+)")
+
+var lintCommand = cli.Command{
+	Name:      "lint",
+	Usage:     "check vim configs for malformed directives, the active configs by default",
+	ArgsUsage: "[<file or dir> ...]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "strict",
+			Usage: "fail on warnings too",
+		},
+	},
+	Action: func(c *cli.Context) {
+		app := getApp(c)
+		files := []string{}
+		if len(c.Args()) == 0 {
+			for _, config := range app.activeConfigs() {
+				files = append(files, config.path)
+			}
+		}
+		for _, arg := range c.Args() {
+			if !dry.FileIsDir(arg) {
+				files = append(files, arg)
+				continue
+			}
+			fis, err := ioutil.ReadDir(arg)
+			if err != nil {
+				app.fatal("cannot access to '%s' (error: %s)", arg, err)
+			}
+			for _, fi := range fis {
+				if isVimConfigFile(fi.Name()) {
+					files = append(files, path.Join(arg, fi.Name()))
+				}
+			}
+		}
+
+		diags := app.lintVimConfigs(files)
+		for _, d := range diags {
+			if app.jsonOutput {
+				app.event("diagnostic", eventFields{"path": d.path, "line": d.line, "col": d.col, "severity": d.severity, "message": d.message})
+			} else {
+				fmt.Println(d)
+			}
+		}
+		errors, warnings := countDiagnostics(diags)
+		if code := lintExitCode(diags, c.Bool("strict")); code != 0 {
+			app.err("%d error(s), %d warning(s) in %d vim config(s)", errors, warnings, len(files))
+			app.exit(code)
+		}
+		app.success("%d vim config(s) checked, %d warning(s)", len(files), warnings)
+	},
+}
+
+func countDiagnostics(diags []diagnostic) (errors, warnings int) {
+	for _, d := range diags {
+		if d.severity == _SEVERITY_ERROR {
+			errors++
+		} else {
+			warnings++
+		}
+	}
+	return errors, warnings
+}
+
+// lintExitCode returns the exit code of 'lint', the warnings only fail it in
+// strict mode
+func lintExitCode(diags []diagnostic, strict bool) int {
+	errors, warnings := countDiagnostics(diags)
+	if errors > 0 || (warnings > 0 && strict) {
+		return _EXIT_FAILED
+	}
+	return 0
+}
+
+// lintVimConfigs checks the directives of each file, the plugins required by
+// more than one of them and the files they source
+func (app *_appContext) lintVimConfigs(files []string) []diagnostic {
+	diags := []diagnostic{}
+	type requireSite struct {
+		path string
+		line int
+		ref  string
+	}
+	required := make(map[string]requireSite)
+
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			diags = append(diags, diagnostic{file, 1, 1, _SEVERITY_ERROR, err.Error()})
+			continue
+		}
+		config, fileDiags, err := scanVimConfig(f, file)
+		f.Close()
+		if err != nil {
+			fileDiags = append(fileDiags, diagnostic{file, 1, 1, _SEVERITY_ERROR, err.Error()})
+		}
+
+		for _, d := range config.directives {
+			if d.kind != _DIRECTIVE_REQUIRE && d.kind != _DIRECTIVE_OPTIONAL_REQUIRE {
+				continue
+			}
+			spec := newPluginSpec(d.value, config.name)
+			if strings.ContainsAny(spec.url, " \t") {
+				fileDiags = append(fileDiags, diagnostic{file, d.line, d.col, _SEVERITY_ERROR, "invalid plugin url: " + spec.url})
+				continue
+			}
+			name, _ := getPluginNameFromUrl(spec.url)
+			if name == "" {
+				fileDiags = append(fileDiags, diagnostic{file, d.line, d.col, _SEVERITY_WARNING,
+					"'" + spec.url + "' is not a plugin url, it will be searched on vimawesome.com"})
+				continue
+			}
+			if site, ok := required[name]; ok {
+				severity, what := _SEVERITY_WARNING, "required already"
+				if site.ref != spec.ref {
+					severity, what = _SEVERITY_ERROR, "required with another ref"
+				}
+				fileDiags = append(fileDiags, diagnostic{file, d.line, d.col, severity,
+					fmt.Sprintf("plugin %s is %s at %s:%d", name, what, site.path, site.line)})
+				continue
+			}
+			required[name] = requireSite{file, d.line, spec.ref}
+		}
+
+		fileDiags = append(fileDiags, lintSources(file)...)
+		sort.SliceStable(fileDiags, func(i, j int) bool {
+			if fileDiags[i].line != fileDiags[j].line {
+				return fileDiags[i].line < fileDiags[j].line
+			}
+			return fileDiags[i].col < fileDiags[j].col
+		})
+		diags = append(diags, fileDiags...)
+	}
+	return diags
+}
+
+// lintSources reports the ':source' commands of a vim config whose file is
+// missing, the targets using vim expressions or relative paths are skipped
+func lintSources(file string) []diagnostic {
+	diags := []diagnostic{}
+	f, err := os.Open(file)
+	if err != nil {
+		return diags
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		m := _SOURCE_PATTERN.FindStringSubmatchIndex(scanner.Text())
+		if m == nil {
+			continue
+		}
+		target := scanner.Text()[m[2]:m[3]]
+		if strings.ContainsAny(target, "<`%#") || strings.Contains(target, "expand(") {
+			continue
+		}
+		expanded := os.ExpandEnv(expandHome(target))
+		if !path.IsAbs(expanded) {
+			continue
+		}
+		if !dry.FileExists(expanded) {
+			diags = append(diags, diagnostic{file, lineNo, m[2] + 1, _SEVERITY_ERROR, "sourced file doesn't exist: " + target})
+		}
+	}
+	return diags
+}
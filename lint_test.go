@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestLintVimConfigs(t *testing.T) {
+	dir := t.TempDir()
+	a, b := path.Join(dir, "a.vimrc"), path.Join(dir, "b.vimrc")
+	ioutil.WriteFile(a, []byte("\" @require: tpope/x#v1\n"+
+		"so /nonexistent/file.vim\n"+
+		"  :source! "+b+"\n"+
+		"so <sfile>:h/x.vim\n"+
+		"so relative.vim\n"), 0644)
+	ioutil.WriteFile(b, []byte("set nu\n"+
+		"\" @require: tpope/x#v2\n"+
+		"\" @optional-require: tpope/x#v1\n"+
+		"\" @require: not a url\n"+
+		"\" @require: nerdtree\n"+
+		"\" @requre: tpope/y\n"), 0644)
+	missing := path.Join(dir, "missing.vimrc")
+
+	app := &_appContext{logger: newLogger(_LEVEL_QUIET)}
+	diags := app.lintVimConfigs([]string{a, b, missing})
+	got := []string{}
+	for _, d := range diags {
+		got = append(got, strings.Replace(d.String(), dir+"/", "", -1))
+	}
+	want := []string{
+		"a.vimrc:2:4: error: sourced file doesn't exist: /nonexistent/file.vim",
+		"b.vimrc:2:13: error: plugin x is required with another ref at a.vimrc:1",
+		"b.vimrc:3:22: warning: plugin x is required already at a.vimrc:1",
+		"b.vimrc:4:13: error: invalid plugin url: not a url",
+		"b.vimrc:5:13: warning: 'nerdtree' is not a plugin url, it will be searched on vimawesome.com",
+		"b.vimrc:6:3: error: unknown directive @requre, did you mean @require?",
+	}
+	if len(got) != len(want)+1 || strings.Join(got[:len(want)], "\n") != strings.Join(want, "\n") {
+		t.Fatalf("diagnostics =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if d := diags[len(want)]; d.path != missing || d.line != 1 || d.col != 1 || d.severity != _SEVERITY_ERROR {
+		t.Errorf("diagnostic of a missing file = %s", d)
+	}
+}
+
+func TestLintSources(t *testing.T) {
+	dir := t.TempDir()
+	file := path.Join(dir, "a.vimrc")
+	ioutil.WriteFile(file, []byte("set nu\n"+
+		"\tsou /nonexistent/a.vim\n"+
+		":so! /nonexistent/b.vim\n"+
+		"so "+file+"\n"+
+		"so `pwd`/x.vim\n"+
+		"source %:h/x.vim\n"+
+		"exe 'so ' . expand('~/x.vim')\n"), 0644)
+	got := []string{}
+	for _, d := range lintSources(file) {
+		got = append(got, fmt.Sprintf("%d:%d: %s", d.line, d.col, d.message))
+	}
+	want := []string{
+		"2:6: sourced file doesn't exist: /nonexistent/a.vim",
+		"3:6: sourced file doesn't exist: /nonexistent/b.vim",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("diagnostics =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLintExitCode(t *testing.T) {
+	warning := diagnostic{"a.vimrc", 1, 3, _SEVERITY_WARNING, "unknown directive @x"}
+	error := diagnostic{"a.vimrc", 2, 1, _SEVERITY_ERROR, "@end-script without @run-script"}
+	for _, tc := range []struct {
+		diags  []diagnostic
+		strict bool
+		code   int
+	}{
+		{nil, false, 0},
+		{nil, true, 0},
+		{[]diagnostic{warning}, false, 0},
+		{[]diagnostic{warning}, true, _EXIT_FAILED},
+		{[]diagnostic{error}, false, _EXIT_FAILED},
+		{[]diagnostic{warning, error}, true, _EXIT_FAILED},
+	} {
+		if code := lintExitCode(tc.diags, tc.strict); code != tc.code {
+			t.Errorf("lintExitCode(%v, %v) = %d, want %d", tc.diags, tc.strict, code, tc.code)
+		}
+	}
+}
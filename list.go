@@ -2,10 +2,11 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"path"
 
 	"github.com/codegangsta/cli"
-	"github.com/ungerik/go-dry"
 )
 
 var listCommand = cli.Command{
@@ -13,15 +14,34 @@ var listCommand = cli.Command{
 	Usage:   "list installed vim plugins",
 	Aliases: []string{"ls"},
 	Action: func(c *cli.Context) {
-		vimdir := c.GlobalString("vimdir")
-		fmt.Println("List plugins:")
-		fl, err := dry.ListDirDirectories(path.Join(vimdir, "bundle"))
-		if err != nil {
-			fmt.Printf("cannot access to '%s' (error: %s)\n", vimdir, err)
+		app := getApp(c)
+		fl, err := ioutil.ReadDir(app.bundleDir)
+		if err != nil && !os.IsNotExist(err) {
+			app.err("cannot access to '%s' (error: %s)", app.bundleDir, err)
+			app.failCommand(err)
 			return
 		}
+		if !app.jsonOutput {
+			fmt.Println("List plugins:")
+		}
 		for _, plugin := range fl {
-			fmt.Println(" ", plugin)
+			fields := eventFields{"plugin": plugin.Name()}
+			if plugin.Mode()&os.ModeSymlink != 0 {
+				fields["link"], _ = os.Readlink(path.Join(app.bundleDir, plugin.Name()))
+			} else if !plugin.IsDir() {
+				continue
+			}
+			if state := app.pluginState(plugin.Name()); state != nil {
+				fields["url"], fields["ref"], fields["commit"] = state.URL, state.Ref, state.Commit
+			}
+			switch {
+			case app.jsonOutput:
+				app.event("plugin", fields)
+			case fields["link"] != nil:
+				fmt.Printf("  %s -> %s (linked)\n", plugin.Name(), fields["link"])
+			default:
+				fmt.Println(" ", plugin.Name())
+			}
 		}
 	},
 }
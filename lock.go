@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// lockVimDir takes an advisory lock on the vim directory, so two runs can't
+// wipe the tmp dir, clone into the same bundle or race on states.yml.
+// The lock is held by flock(2) which the kernel releases when a run crashes,
+// the pid left in the lock file by such run is only reported as stale.
+func (app *_appContext) lockVimDir() error {
+	if err := os.MkdirAll(app.vimDir, 0755); err != nil {
+		return err
+	}
+	lockPath := path.Join(app.vimDir, ".lock")
+	file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	fd := int(file.Fd())
+	if err := syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if err != syscall.EWOULDBLOCK {
+			file.Close()
+			return err
+		}
+		pid := readLockPid(file)
+		if !app.waitLock {
+			file.Close()
+			return errors.New("another instance (pid " + strconv.Itoa(pid) + ") is running, use --wait to wait for it")
+		}
+		app.info("another instance (pid %d) is running, waiting for it ...", pid)
+		if err := syscall.Flock(fd, syscall.LOCK_EX); err != nil {
+			file.Close()
+			return err
+		}
+	} else if pid := readLockPid(file); pid > 0 {
+		app.debug("recover stale lock left by pid %d", pid)
+	}
+
+	file.Truncate(0)
+	file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	app.lockFile = file
+	return nil
+}
+
+func (app *_appContext) unlockVimDir() {
+	if app.lockFile == nil {
+		return
+	}
+	app.lockFile.Truncate(0)
+	syscall.Flock(int(app.lockFile.Fd()), syscall.LOCK_UN)
+	app.lockFile.Close()
+	app.lockFile = nil
+}
+
+func readLockPid(file *os.File) int {
+	file.Seek(0, 0)
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+// lockCacheFile takes a flock(2) lock on a file of the cache dir, which the vim
+// dirs of several users or containers may share. It waits for the other runs
+// holding the lock unless wait is false.
+func (app *_appContext) lockCacheFile(name string, how int, wait bool) (*os.File, error) {
+	lockPath := path.Join(app.cacheDir, name)
+	if err := os.MkdirAll(path.Dir(lockPath), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fd := int(file.Fd())
+	if err := syscall.Flock(fd, how|syscall.LOCK_NB); err == nil {
+		return file, nil
+	} else if err != syscall.EWOULDBLOCK {
+		file.Close()
+		return nil, err
+	}
+	if !wait {
+		file.Close()
+		return nil, errors.New("another instance is using the cache, use --wait to wait for it")
+	}
+	app.debug("wait for the lock", lockPath)
+	if err := syscall.Flock(fd, how); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func unlockCacheFile(file *os.File) {
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	file.Close()
+}
+
+// useCache takes a shared lock on the cache for the rest of the run, so
+// 'cache prune' doesn't remove a mirror or a download in use
+func (app *_appContext) useCache() error {
+	if app.cacheLock != nil {
+		return nil
+	}
+	file, err := app.lockCacheFile(".lock", syscall.LOCK_SH, true)
+	if err != nil {
+		return err
+	}
+	app.cacheLock = file
+	return nil
+}
+
+// lockCache takes the exclusive lock on the cache of 'cache prune'
+func (app *_appContext) lockCache() error {
+	file, err := app.lockCacheFile(".lock", syscall.LOCK_EX, app.waitLock)
+	if err != nil {
+		return err
+	}
+	app.cacheLock = file
+	return nil
+}
@@ -2,32 +2,40 @@ package main
 
 import (
 	"bytes"
-	"errors"
+	"net/http"
 	"os"
 	"os/user"
 	"path"
 	"strings"
 
 	"github.com/codegangsta/cli"
-	"github.com/fatih/color"
 	"github.com/ungerik/go-dry"
 )
 
 type _appContext struct {
-	cmdName        string
-	vimDir         string
-	vimrcPath      string
-	bundleDir      string
-	configDir      string
-	autoloadDir    string
-	tmpDir         string
-	vimrcBuf       *bytes.Buffer
-	oldVimrcBuf    *bytes.Buffer
-	generatedVimrc bool
-	verboseFlag    bool
-	enableDebug    bool
-	forceUpdate    bool
-	states         map[string]interface{}
+	*logger
+	cmdName         string
+	vimDir          string
+	vimrcPath       string
+	bundleDir       string
+	configDir       string
+	systemConfigDir string
+	autoloadDir     string
+	tmpDir          string
+	vimrcBuf        *bytes.Buffer
+	oldVimrcBuf     *bytes.Buffer
+	generatedVimrc  bool
+	forceUpdate     bool
+	waitLock        bool
+	offline         bool
+	cacheDir        string
+	lockFile        *os.File
+	cacheLock       *os.File
+	states          *vimStates
+	settings        *vimSettings
+	statesLoaded    bool
+	statesErr       error
+	client          *http.Client
 }
 
 var _app *_appContext
@@ -53,32 +61,176 @@ func main() {
 			Usage: "change .vimrc path",
 			Value: path.Join(_user.HomeDir, ".vimrc"),
 		},
+		cli.StringFlag{
+			Name:  "system-configs",
+			Usage: "change system-wide vim config directory",
+			Value: _SYSTEM_CONFIG_DIR,
+		},
 		cli.BoolFlag{
 			Name:  "force,f",
 			Usage: "force to update",
 		},
 		cli.BoolFlag{
-			Name:  "verbose",
-			Usage: "print more console infomation",
+			Name:  "quiet,q",
+			Usage: "print errors only",
+		},
+		cli.BoolFlag{
+			Name:  "verbose,v",
+			Usage: "print more details, -vv for debug information",
+		},
+		cli.StringFlag{
+			Name:  "cache-dir",
+			Usage: "change the cache directory of plugin repositories and downloads",
+			Value: path.Join(_user.HomeDir, ".cache", "vim-plugin-setup"),
+		},
+		cli.BoolFlag{
+			Name:  "offline",
+			Usage: "install from the cache only, never access the network",
+		},
+		cli.BoolFlag{
+			Name:  "wait",
+			Usage: "wait for another running instance instead of failing",
 		},
 		cli.BoolFlag{
 			Name:  "debug",
-			Usage: "show debug information",
+			Usage: "print debug information, same as -vv",
+		},
+		cli.BoolFlag{
+			Name:  "show-output",
+			Usage: "stream the output of git and scripts",
+		},
+		cli.StringFlag{
+			Name:  "output",
+			Usage: "output format, text or json (newline-delimited events)",
+			Value: _OUTPUT_TEXT,
+		},
+		cli.StringFlag{
+			Name:  "log-file",
+			Usage: "append the events of the command to a file as json lines",
 		},
 	}
-	app.Before = checkBeforeRun
 	app.Commands = []cli.Command{
+		setupCommand,
 		installCommand,
 		listCommand,
+		updateCommand,
+		cleanCommand,
 		removeCommand,
+		exportManifestCommand,
+		importCommand,
+		exportCommand,
+		importBundleCommand,
+		configsCommand,
+		sourceCommand,
+		profileCommand,
+		scriptsCommand,
+		lintCommand,
+		auditCommand,
+		cacheCommand,
+	}
+
+	// -v is the verbose flag
+	cli.VersionFlag = cli.BoolFlag{
+		Name:  "version, V",
+		Usage: "print the version",
+	}
+	code := 0
+	if err := app.Run(expandVerbosityArgs(os.Args)); err != nil {
+		code = _EXIT_FAILED
+	}
+	if _app != nil {
+		_app.exit(code)
 	}
+	os.Exit(code)
+}
 
-	app.Run(os.Args)
+// expandVerbosityArgs turns -vv into --debug, the cli package doesn't count
+// repeated flags
+func expandVerbosityArgs(args []string) []string {
+	expanded := []string{}
+	for i, arg := range args {
+		if arg == "--" {
+			return append(expanded, args[i:]...)
+		}
+		if arg == "-vv" {
+			arg = "--debug"
+		}
+		expanded = append(expanded, arg)
+	}
+	return expanded
 }
 
 var _PREREQUISITES = []string{"bash", "git", "vim", "wget", "cmake"}
 
-func checkBeforeRun(c *cli.Context) error {
+// getApp initializes the application context at the first use, it only reads
+// the global flags and the saved states, so it's safe for read-only commands
+func getApp(c *cli.Context) *_appContext {
+	if _app == nil {
+		_app = newAppContext(c)
+	}
+	return _app
+}
+
+func newAppContext(c *cli.Context) *_appContext {
+	app := new(_appContext)
+	level := _LEVEL_NORMAL
+	switch {
+	case c.GlobalBool("debug"):
+		level = _LEVEL_DEBUG
+	case c.GlobalBool("verbose"):
+		level = _LEVEL_VERBOSE
+	case c.GlobalBool("quiet"):
+		level = _LEVEL_QUIET
+	}
+	app.logger = newLogger(level)
+	app.showOutput = c.GlobalBool("show-output")
+	app.forceUpdate = c.GlobalBool("force")
+	app.waitLock = c.GlobalBool("wait")
+	app.offline = c.GlobalBool("offline")
+
+	app.vimDir = c.GlobalString("vimdir")
+	app.vimrcPath = c.GlobalString("vimrc")
+	app.bundleDir = path.Join(app.vimDir, "bundle")
+	app.autoloadDir = path.Join(app.vimDir, "autoload")
+	app.configDir = path.Join(app.vimDir, "configs")
+	app.systemConfigDir = c.GlobalString("system-configs")
+	app.tmpDir = path.Join(app.vimDir, "tmp")
+	app.cacheDir = c.GlobalString("cache-dir")
+	app.cmdName = path.Base(os.Args[0])
+	app.openEvents(c)
+
+	if err := app.loadSettings(); err != nil {
+		app.fatal("invalid %s (error: %s)", app.settingsFile(), err)
+	}
+	_resolver = newURLResolver(app.settings)
+
+	app.statesErr = app.loadStates()
+	return app
+}
+
+// beginUpdate prepares the context for the commands which change the vim
+// directory: it locks the directory and creates a fresh tmp dir. The returned
+// function saves the states and releases the lock, call it when done.
+func beginUpdate(c *cli.Context) (*_appContext, func()) {
+	app := getApp(c)
+	app.requireStates()
+	if err := app.lockVimDir(); err != nil {
+		app.fatal("unable to lock %s (%s)", app.vimDir, err)
+	}
+	// another run may have changed them while this one waited for the lock
+	app.statesErr = app.loadStates()
+	app.requireStates()
+	cleanup := app.prepareVimDirs()
+	return app, func() {
+		app.saveStates()
+		cleanup()
+		app.unlockVimDir()
+	}
+}
+
+// checkPrerequisites exits if a command needed to install the plugins is
+// missing
+func (app *_appContext) checkPrerequisites() {
 	preqMissing := []string{}
 	for _, preq := range _PREREQUISITES {
 		exists := false
@@ -94,15 +246,6 @@ func checkBeforeRun(c *cli.Context) error {
 		}
 	}
 	if len(preqMissing) > 0 {
-		color.Red("Missing prequisite(s): %+v", preqMissing)
-		return errors.New("missing prequisites")
+		app.fatal("missing prequisite(s): %s", strings.Join(preqMissing, ", "))
 	}
-
-	_app = new(_appContext)
-	_app.states = make(map[string]interface{})
-	_app.verboseFlag = !c.GlobalBool("verbose")
-	_app.enableDebug = c.GlobalBool("debug")
-	_app.forceUpdate = c.GlobalBool("force")
-
-	return _app.setupVimPlugins(c)
 }
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/ungerik/go-dry"
+	"gopkg.in/yaml.v2"
+)
+
+// manifestPlugin is a plugin declared in plugins.yml, it's installed only if
+// it's enabled and all of its conditions are met
+type manifestPlugin struct {
+	URL      string   `yaml:"url"`
+	Ref      string   `yaml:"ref,omitempty"`
+	Build    string   `yaml:"build,omitempty"`
+	Config   string   `yaml:"config,omitempty"`
+	Enabled  *bool    `yaml:"enabled,omitempty"`
+	OS       []string `yaml:"os,omitempty"`
+	Commands []string `yaml:"commands,omitempty"`
+}
+
+type pluginManifest struct {
+	Plugins []manifestPlugin `yaml:"plugins"`
+}
+
+var exportManifestCommand = cli.Command{
+	Name:  "export-manifest",
+	Usage: "generate plugins.yml from the '@require' directives of vim configs",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "output,o",
+			Usage: "write the manifest to this file instead of plugins.yml, '-' for stdout",
+		},
+	},
+	Action: func(c *cli.Context) {
+		app := getApp(c)
+		configs, err := app.parseVimConfigs()
+		if err != nil {
+			app.fatal("unable to read vim configs (error: %s)", err)
+		}
+
+		data, err := yaml.Marshal(exportManifest(configs))
+		if err != nil {
+			app.fatal("unable to encode manifest (error: %s)", err)
+		}
+		data = append([]byte("# generated by '"+app.cmdName+" export-manifest'\n"), data...)
+
+		output := c.String("output")
+		if output == "" {
+			output = app.manifestFile()
+		}
+		if output == "-" {
+			if app.jsonOutput {
+				app.event("manifest", eventFields{"content": string(data)})
+				return
+			}
+			os.Stdout.Write(data)
+			return
+		}
+		if dry.FileExists(output) && !app.forceUpdate {
+			app.fatal("%s already exists, use --force to overwrite it", output)
+		}
+		if err := ioutil.WriteFile(output, data, 0644); err != nil {
+			app.fatal("unable to write %s (error: %s)", output, err)
+		}
+		app.event("file.written", eventFields{"path": output})
+		app.success("manifest is written to %s", output)
+	},
+}
+
+func (app *_appContext) manifestFile() string {
+	return path.Join(app.vimDir, "plugins.yml")
+}
+
+// loadManifest reads plugins.yml, it returns nil if there is no manifest
+func (app *_appContext) loadManifest() (*pluginManifest, error) {
+	data, err := ioutil.ReadFile(app.manifestFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		app.err("unable to read %s (error: %s)", app.manifestFile(), err)
+		return nil, err
+	}
+	manifest := &pluginManifest{}
+	if err := yaml.Unmarshal(data, manifest); err != nil {
+		app.err("invalid manifest %s (error: %s)", app.manifestFile(), err)
+		return nil, err
+	}
+	for i, p := range manifest.Plugins {
+		if p.URL == "" {
+			err := fmt.Errorf("plugin #%d has no url", i+1)
+			app.err("invalid manifest %s (error: %s)", app.manifestFile(), err)
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+// active tells whether the plugin should be installed, or why not
+func (p *manifestPlugin) active() (bool, string) {
+	if p.Enabled != nil && !*p.Enabled {
+		return false, "disabled"
+	}
+	if len(p.OS) > 0 {
+		found := false
+		for _, os := range p.OS {
+			if os == runtime.GOOS {
+				found = true
+			}
+		}
+		if !found {
+			return false, "not for " + runtime.GOOS
+		}
+	}
+	for _, command := range p.Commands {
+		if _, err := exec.LookPath(command); err != nil {
+			return false, "missing command " + command
+		}
+	}
+	return true, ""
+}
+
+// pluginPlan is the merged result of the manifest and the '@require'
+// directives, the manifest wins when they conflict
+type pluginPlan struct {
+	specs           map[string]*pluginSpec
+	order           []string
+	required        map[string]bool
+	disabledConfigs map[string]bool
+}
+
+func pluginKey(url string) string {
+	if name, _ := getPluginNameFromUrl(url); name != "" {
+		return name
+	}
+	return url
+}
+
+// lookup returns the merged spec of a plugin required by a vim config
+func (plan *pluginPlan) lookup(plugin string) *pluginSpec {
+	spec := newPluginSpec(plugin, "")
+	if merged, ok := plan.specs[pluginKey(spec.url)]; ok {
+		return merged
+	}
+	return spec
+}
+
+// unattached returns the enabled plugins which are only declared in the
+// manifest
+func (plan *pluginPlan) unattached() []*pluginSpec {
+	specs := []*pluginSpec{}
+	for _, key := range plan.order {
+		if spec := plan.specs[key]; spec.enabled && !plan.required[key] {
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+func (app *_appContext) planPlugins(manifest *pluginManifest, configs []*vimConfig) *pluginPlan {
+	plan := &pluginPlan{
+		specs:           make(map[string]*pluginSpec),
+		required:        make(map[string]bool),
+		disabledConfigs: make(map[string]bool),
+	}
+
+	if manifest != nil {
+		for _, p := range manifest.Plugins {
+			spec := &pluginSpec{
+				url:        p.URL,
+				ref:        p.Ref,
+				build:      p.Build,
+				config:     p.Config,
+				declaredIn: "plugins.yml",
+				enabled:    true,
+				link:       strings.HasPrefix(p.URL, "file://"),
+			}
+			key := pluginKey(spec.url)
+			if _, ok := plan.specs[key]; ok {
+				app.warn("plugin %s is declared more than once in plugins.yml, ignore %s", key, spec.url)
+				continue
+			}
+			if ok, reason := p.active(); !ok {
+				app.info("skip plugin %s (%s)", key, reason)
+				spec.enabled = false
+				if p.Config != "" {
+					plan.disabledConfigs[p.Config] = true
+				}
+			}
+			if p.Config != "" && !dry.FileExists(path.Join(app.configDir, p.Config)) {
+				app.warn("vim config %s of plugin %s doesn't exist", p.Config, key)
+			}
+			plan.specs[key] = spec
+			plan.order = append(plan.order, key)
+		}
+	}
+
+	for _, config := range configs {
+		for _, plugin := range config.requires() {
+			spec := newPluginSpec(plugin, config.name)
+			key := pluginKey(spec.url)
+			plan.required[key] = true
+			merged, ok := plan.specs[key]
+			if !ok {
+				plan.specs[key] = spec
+				plan.order = append(plan.order, key)
+				continue
+			}
+			if !merged.enabled && !config.optional(plugin) {
+				plan.disabledConfigs[config.name] = true
+			}
+			if conflict := specConflict(merged, spec); conflict != "" {
+				app.warn("conflict of plugin %s: %s, use the one from %s", key, conflict, merged.declaredIn)
+			}
+			if merged.config == "" {
+				merged.config = config.name
+			}
+		}
+	}
+
+	return plan
+}
+
+func specConflict(a, b *pluginSpec) string {
+	_, urlA := getPluginNameFromUrl(a.url)
+	_, urlB := getPluginNameFromUrl(b.url)
+	if urlA != urlB {
+		return fmt.Sprintf("%s in %s but %s in %s", a.url, a.declaredIn, b.url, b.declaredIn)
+	}
+	if a.ref != b.ref {
+		refA, refB := a.ref, b.ref
+		if refA == "" {
+			refA = "default branch"
+		}
+		if refB == "" {
+			refB = "default branch"
+		}
+		return fmt.Sprintf("%s in %s but %s in %s", refA, a.declaredIn, refB, b.declaredIn)
+	}
+	return ""
+}
+
+// exportManifest collects the '@require' directives of vim configs, the first
+// vim config which requires a plugin is associated with it
+func exportManifest(configs []*vimConfig) *pluginManifest {
+	manifest := &pluginManifest{Plugins: []manifestPlugin{}}
+	exported := make(map[string]bool)
+	for _, config := range configs {
+		for _, plugin := range config.requires() {
+			spec := newPluginSpec(plugin, config.name)
+			key := pluginKey(spec.url)
+			if exported[key] {
+				continue
+			}
+			exported[key] = true
+			manifest.Plugins = append(manifest.Plugins, manifestPlugin{
+				URL:    spec.url,
+				Ref:    spec.ref,
+				Config: config.name,
+			})
+		}
+	}
+	return manifest
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// _DEFAULT_NETWORK_TIMEOUT bounds the connection and the wait for an answer,
+// not the whole transfer, a large download on a slow link is fine
+const _DEFAULT_NETWORK_TIMEOUT = 30 * time.Second
+
+// _SYSTEM_CA_FILES are where the distributions put the bundle of trusted
+// certificates, the custom CA files are added to the first one found for git
+var _SYSTEM_CA_FILES = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// networkSettings are the 'network' of settings.yml, they apply to both the
+// http client and git. Unlike the rewrites, mirrors don't change the url of
+// the plugins, only where they are fetched from.
+//
+//	network:
+//	  proxy: http://proxy.corp.example:3128
+//	  no_proxy: localhost,.corp.example
+//	  ca_files: [/etc/corp/root-ca.pem]
+//	  timeout: 1m
+//	  mirrors:
+//	    - match: https://github.com/
+//	      replace: https://git-mirror.corp.example/github/
+type networkSettings struct {
+	Proxy   string        `yaml:"proxy,omitempty"`
+	NoProxy string        `yaml:"no_proxy,omitempty"`
+	CAFiles []string      `yaml:"ca_files,omitempty"`
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	Mirrors []urlRewrite  `yaml:"mirrors,omitempty"`
+}
+
+func (app *_appContext) networkTimeout() time.Duration {
+	if app.settings.Network.Timeout > 0 {
+		return app.settings.Network.Timeout
+	}
+	return _DEFAULT_NETWORK_TIMEOUT
+}
+
+// mirrorURL returns where url is fetched from
+func (app *_appContext) mirrorURL(url string) string {
+	for _, m := range app.settings.Network.Mirrors {
+		if m.Match != "" && strings.HasPrefix(url, m.Match) {
+			return m.Replace + url[len(m.Match):]
+		}
+	}
+	return url
+}
+
+// proxyFor returns the proxy of a request, the one of settings.yml or else
+// the one of the environment
+func (app *_appContext) proxyFor(req *http.Request) (*neturl.URL, error) {
+	network := app.settings.Network
+	if network.Proxy == "" {
+		return http.ProxyFromEnvironment(req)
+	}
+	if noProxy(req.URL.Hostname(), network.NoProxy) {
+		return nil, nil
+	}
+	return neturl.Parse(network.Proxy)
+}
+
+// noProxy tells if host is in a comma separated list of hosts and domains,
+// like the no_proxy environment variable
+func noProxy(host, list string) bool {
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+		case entry == "*", entry == host:
+			return true
+		case strings.HasSuffix(host, "."+strings.TrimPrefix(entry, ".")):
+			return true
+		}
+	}
+	return false
+}
+
+// httpClient returns the client of every download, built once from the
+// network settings
+func (app *_appContext) httpClient() *http.Client {
+	if app.client != nil {
+		return app.client
+	}
+	timeout := app.networkTimeout()
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = app.proxyFor
+	transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = timeout
+	transport.ResponseHeaderTimeout = timeout
+	if len(app.settings.Network.CAFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, file := range app.settings.Network.CAFiles {
+			data, err := ioutil.ReadFile(expandHome(file))
+			if err != nil {
+				app.warn("unable to read CA file %s (error: %s)", file, err)
+			} else if !pool.AppendCertsFromPEM(data) {
+				app.warn("no certificate in CA file %s", file)
+			}
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	app.client = &http.Client{Transport: transport}
+	return app.client
+}
+
+// gitNetworkArgs returns the git config of the network settings, as '-c'
+// options which are passed on to the git commands git runs itself, e.g. for
+// the submodules
+func (app *_appContext) gitNetworkArgs() []string {
+	network := app.settings.Network
+	args := []string{}
+	if network.Proxy != "" {
+		args = append(args, "-c", "http.proxy="+network.Proxy)
+	}
+	if len(network.CAFiles) > 0 {
+		if bundle, err := app.caBundle(); err != nil {
+			app.warn("unable to write the CA bundle for git (error: %s)", err)
+		} else {
+			args = append(args, "-c", "http.sslCAInfo="+bundle)
+		}
+	}
+	// abort the transfers stalled for the timeout
+	args = append(args, "-c", "http.lowSpeedLimit=1", "-c", fmt.Sprintf("http.lowSpeedTime=%d", int(app.networkTimeout()/time.Second)))
+	for _, m := range network.Mirrors {
+		if m.Match != "" {
+			args = append(args, "-c", "url."+m.Replace+".insteadOf="+m.Match)
+		}
+	}
+	return args
+}
+
+// gitNetworkEnv returns the environment of the network settings git reads no
+// config for
+func (app *_appContext) gitNetworkEnv() []string {
+	if app.settings.Network.Proxy == "" || app.settings.Network.NoProxy == "" {
+		return nil
+	}
+	return []string{"no_proxy=" + app.settings.Network.NoProxy, "NO_PROXY=" + app.settings.Network.NoProxy}
+}
+
+// caBundle writes the system certificates and the custom CA files into a
+// single file, http.sslCAInfo of git replaces the system bundle rather than
+// adding to it
+func (app *_appContext) caBundle() (string, error) {
+	bundle := path.Join(app.tmpDir, "ca-bundle.pem")
+	if _, err := os.Stat(bundle); err == nil {
+		return bundle, nil
+	}
+	var buf bytes.Buffer
+	system := append([]string{os.Getenv("SSL_CERT_FILE")}, _SYSTEM_CA_FILES...)
+	for _, file := range system {
+		if data, err := ioutil.ReadFile(file); file != "" && err == nil {
+			buf.Write(data)
+			buf.WriteString("\n")
+			break
+		}
+	}
+	for _, file := range app.settings.Network.CAFiles {
+		data, err := ioutil.ReadFile(expandHome(file))
+		if err != nil {
+			return "", err
+		}
+		buf.Write(data)
+		buf.WriteString("\n")
+	}
+	os.MkdirAll(app.tmpDir, 0755)
+	return bundle, ioutil.WriteFile(bundle, buf.Bytes(), 0644)
+}
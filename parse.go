@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// _DIRECTIVE_PATTERN matches a directive in a comment, e.g. '" @require: ...'
+var _DIRECTIVE_PATTERN = regexp.MustCompile("^\\s*\"\\s*@([A-Za-z][A-Za-z0-9_-]*)(.*)$")
+var _SCRIPT_LINE_PATTERN = regexp.MustCompile("^\\s*\"(.*)$")
+var _RUN_SCRIPT_ARGS_PATTERN = regexp.MustCompile("^\\s*(?:\\([^\\)]*\\))?\\s*$")
+
+// _DIRECTIVE_ALIASES are the spellings accepted for the directives
+var _DIRECTIVE_ALIASES = map[string]string{
+	"require":          _DIRECTIVE_REQUIRE,
+	"require-plugin":   _DIRECTIVE_REQUIRE,
+	"optional-require": _DIRECTIVE_OPTIONAL_REQUIRE,
+	"run-script":       _DIRECTIVE_SCRIPT,
+	"end-script":       "end-script",
+}
+
+const (
+	_SEVERITY_ERROR   = "error"
+	_SEVERITY_WARNING = "warning"
+)
+
+// diagnostic is a problem found in a vim config, line and col start at 1
+type diagnostic struct {
+	path     string
+	line     int
+	col      int
+	severity string
+	message  string
+}
+
+func (d diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.path, d.line, d.col, d.severity, d.message)
+}
+
+// parseVimConfig reads the directives of a vim config, the problems of a
+// malformed one are reported by 'lint' only
+func parseVimConfig(configFilepath string) (*vimConfig, error) {
+	file, err := os.Open(configFilepath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	config, _, err := scanVimConfig(file, configFilepath)
+	return config, err
+}
+
+// scanVimConfig parses the directives in the comments of a vim config:
+//
+//	" @require: <plugin>[#<ref>]
+//	" @optional-require: <plugin>[#<ref>]
+//	" @run-script
+//	" <script line>
+//	" @end-script
+//
+// and reports what it ignores as diagnostics
+func scanVimConfig(r io.Reader, configFilepath string) (*vimConfig, []diagnostic, error) {
+	config := &vimConfig{
+		name: path.Base(configFilepath),
+		path: configFilepath,
+	}
+	diags := []diagnostic{}
+	report := func(line, col int, severity, format string, a ...interface{}) {
+		diags = append(diags, diagnostic{configFilepath, line, col, severity, fmt.Sprintf(format, a...)})
+	}
+
+	var script *strings.Builder
+	scriptLine, scriptCol := 0, 0
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		m := _DIRECTIVE_PATTERN.FindStringSubmatchIndex(line)
+		if m == nil {
+			if script == nil {
+				continue
+			}
+			if ss := _SCRIPT_LINE_PATTERN.FindStringSubmatch(line); ss != nil {
+				script.WriteString(ss[1])
+				script.WriteString("\n")
+			} else {
+				report(lineNo, indentCol(line), _SEVERITY_ERROR, "script line must start with '\"', it's ignored")
+			}
+			continue
+		}
+
+		name, rest := line[m[2]:m[3]], line[m[4]:m[5]]
+		col := m[2]
+		kind, ok := _DIRECTIVE_ALIASES[name]
+		if !ok && script != nil {
+			// a script line which happens to start with '@'
+			script.WriteString(line[strings.Index(line, "\"")+1:])
+			script.WriteString("\n")
+			continue
+		}
+		if !ok {
+			if known := similarDirective(name); known != "" {
+				report(lineNo, col, _SEVERITY_ERROR, "unknown directive @%s, did you mean @%s?", name, known)
+			} else {
+				report(lineNo, col, _SEVERITY_WARNING, "unknown directive @%s", name)
+			}
+			continue
+		}
+
+		switch kind {
+		case _DIRECTIVE_REQUIRE, _DIRECTIVE_OPTIONAL_REQUIRE:
+			value := strings.TrimSpace(rest)
+			if !strings.HasPrefix(value, ":") {
+				report(lineNo, col, _SEVERITY_ERROR, "missing ':' after @%s, it's ignored", name)
+				continue
+			}
+			value = strings.TrimSpace(value[1:])
+			if value == "" {
+				report(lineNo, col, _SEVERITY_ERROR, "missing plugin after @%s", name)
+				continue
+			}
+			valueCol := m[4] + strings.Index(rest, value) + 1
+			config.directives = append(config.directives, configDirective{line: lineNo, col: valueCol, kind: kind, value: value})
+		case _DIRECTIVE_SCRIPT:
+			if script != nil {
+				report(lineNo, col, _SEVERITY_ERROR, "@run-script inside the script started at %d:%d", scriptLine, scriptCol)
+				continue
+			}
+			if !_RUN_SCRIPT_ARGS_PATTERN.MatchString(rest) {
+				report(lineNo, m[4]+indentCol(rest), _SEVERITY_WARNING, "unexpected text after @run-script")
+			}
+			script = &strings.Builder{}
+			scriptLine, scriptCol = lineNo, col
+		case "end-script":
+			if script == nil {
+				report(lineNo, col, _SEVERITY_ERROR, "@end-script without @run-script")
+				continue
+			}
+			config.directives = append(config.directives, configDirective{line: scriptLine, col: scriptCol, kind: _DIRECTIVE_SCRIPT, value: script.String()})
+			script = nil
+		}
+	}
+	if script != nil {
+		report(scriptLine, scriptCol, _SEVERITY_ERROR, "@run-script is not terminated by @end-script, it's ignored")
+	}
+	return config, diags, scanner.Err()
+}
+
+// indentCol returns the column of the first non-blank character of line
+func indentCol(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t")) + 1
+}
+
+// similarDirective returns the known directive a misspelled one is close to
+func similarDirective(name string) string {
+	best, bestDist := "", 3
+	for known := range _DIRECTIVE_ALIASES {
+		if d := editDistance(strings.ToLower(name), known); d < bestDist {
+			best, bestDist = known, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestScanVimConfigDiagnostics(t *testing.T) {
+	for _, tc := range []struct {
+		desc   string
+		config string
+		diags  []string
+	}{
+		{"valid", "set nu\n\" @require: tpope/x#v1\n\" @run-script\n\" echo 1\n\" @end-script\n", nil},
+		{"misspelled directive", "set nu\n\n\" @requre: tpope/x\n",
+			[]string{"3:3: error: unknown directive @requre, did you mean @require?"}},
+		{"unknown directive", "\" @frobnicate: x\n",
+			[]string{"1:3: warning: unknown directive @frobnicate"}},
+		{"missing ':'", "\"@require tpope/x\n",
+			[]string{"1:2: error: missing ':' after @require, it's ignored"}},
+		{"missing plugin", "  \" @require:\n",
+			[]string{"1:5: error: missing plugin after @require"}},
+		{"unterminated script", "set nu\n\" @run-script\n\" echo 1\n",
+			[]string{"2:3: error: @run-script is not terminated by @end-script, it's ignored"}},
+		{"nested script", "\" @run-script\n\t\" @run-script\n\" @end-script\n",
+			[]string{"2:4: error: @run-script inside the script started at 1:3"}},
+		{"script line without '\"'", "\" @run-script\n  echo 1\n\" @end-script\n",
+			[]string{"2:3: error: script line must start with '\"', it's ignored"}},
+		{"text after @run-script", "\" @run-script bash\n\" @end-script\n",
+			[]string{"1:15: warning: unexpected text after @run-script"}},
+		{"end without run", "\" @end-script\n",
+			[]string{"1:3: error: @end-script without @run-script"}},
+	} {
+		_, diags, err := scanVimConfig(strings.NewReader(tc.config), "configs/test.vimrc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, d := range diags {
+			if d.path != "configs/test.vimrc" {
+				t.Errorf("%s: diagnostic of %s", tc.desc, d.path)
+			}
+			got = append(got, fmt.Sprintf("%d:%d: %s: %s", d.line, d.col, d.severity, d.message))
+		}
+		if strings.Join(got, "\n") != strings.Join(tc.diags, "\n") {
+			t.Errorf("%s: diagnostics =\n%s\nwant\n%s", tc.desc, strings.Join(got, "\n"), strings.Join(tc.diags, "\n"))
+		}
+	}
+}
+
+func TestScanVimConfigDirectives(t *testing.T) {
+	config := "set nu\n" +
+		"\" @require: tpope/x#v1\n" +
+		"  \"  @optional-require :  gh:a/b\n" +
+		"\" @run-script\n" +
+		"\" echo 1\n" +
+		"\" @echo 2\n" +
+		"\" @end-script\n"
+	c, _, err := scanVimConfig(strings.NewReader(config), "configs/test.vimrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []configDirective{
+		{line: 2, col: 13, kind: _DIRECTIVE_REQUIRE, value: "tpope/x#v1"},
+		{line: 3, col: 27, kind: _DIRECTIVE_OPTIONAL_REQUIRE, value: "gh:a/b"},
+		{line: 4, col: 3, kind: _DIRECTIVE_SCRIPT, value: " echo 1\n @echo 2\n"},
+	}
+	if c.name != "test.vimrc" || fmt.Sprint(c.directives) != fmt.Sprint(want) {
+		t.Errorf("directives of %s = %+v, want %+v", c.name, c.directives, want)
+	}
+}
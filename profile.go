@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"gopkg.in/yaml.v2"
+)
+
+// _DEFAULT_PROFILE sources every active vim config
+const _DEFAULT_PROFILE = "default"
+
+// vimProfiles are named sets of vim configs, read from profiles.yml:
+//
+//	profiles:
+//	  go: [common.vimrc, go.vimrc, ycm.vimrc]
+//	  minimal: [common.vimrc]
+type vimProfiles struct {
+	Profiles map[string][]string `yaml:"profiles"`
+}
+
+var profileCommand = cli.Command{
+	Name:  "profile",
+	Usage: "switch between the named sets of vim configs in profiles.yml",
+	Subcommands: []cli.Command{
+		{
+			Name:    "list",
+			Usage:   "list profiles, the active one is marked with '*'",
+			Aliases: []string{"ls"},
+			Action: func(c *cli.Context) {
+				app := getApp(c)
+				profiles, err := app.loadProfiles()
+				if err != nil {
+					app.fatal("unable to load %s (error: %s)", app.profilesFile(), err)
+				}
+				names := []string{_DEFAULT_PROFILE}
+				for name := range profiles.Profiles {
+					if name != _DEFAULT_PROFILE {
+						names = append(names, name)
+					}
+				}
+				sort.Strings(names[1:])
+				if !app.jsonOutput {
+					fmt.Println("List profiles:")
+				}
+				for _, name := range names {
+					if app.jsonOutput {
+						app.event("profile", eventFields{"name": name, "active": name == app.activeProfile(), "configs": profiles.Profiles[name]})
+						continue
+					}
+					mark := " "
+					if name == app.activeProfile() {
+						mark = "*"
+					}
+					configs := "all vim configs"
+					if name != _DEFAULT_PROFILE {
+						configs = strings.Join(profiles.Profiles[name], ", ")
+					}
+					fmt.Printf("%s %-16s %s\n", mark, name, configs)
+				}
+			},
+		},
+		{
+			Name:      "use",
+			Usage:     "activate a profile, install its plugins and regenerate .vimrc",
+			ArgsUsage: "<name>",
+			Action: func(c *cli.Context) {
+				if len(c.Args()) != 1 {
+					getApp(c).fatal("missing profile name")
+				}
+				getApp(c).checkPrerequisites()
+				app, done := beginUpdate(c)
+				defer done()
+				name := c.Args().First()
+				profiles, err := app.loadProfiles()
+				if err != nil {
+					app.fatal("unable to load %s (error: %s)", app.profilesFile(), err)
+				}
+				if _, ok := profiles.Profiles[name]; !ok && name != _DEFAULT_PROFILE {
+					app.fatal("no such profile: %s", name)
+				}
+				if name == _DEFAULT_PROFILE {
+					name = ""
+				}
+				app.states.Profile = name
+				if err := app.setupVimPlugins(); err != nil {
+					app.err("unable to setup profile %s (error: %s)", c.Args().First(), err)
+					app.failCommand(err)
+					return
+				}
+				app.success("profile %s is active", c.Args().First())
+			},
+		},
+	},
+}
+
+func (app *_appContext) profilesFile() string {
+	return path.Join(app.vimDir, "profiles.yml")
+}
+
+func (app *_appContext) loadProfiles() (*vimProfiles, error) {
+	profiles := &vimProfiles{}
+	data, err := ioutil.ReadFile(app.profilesFile())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, profiles); err != nil {
+		return nil, err
+	}
+	if profiles.Profiles == nil {
+		profiles.Profiles = make(map[string][]string)
+	}
+	return profiles, nil
+}
+
+func (app *_appContext) activeProfile() string {
+	if app.states.Profile == "" {
+		return _DEFAULT_PROFILE
+	}
+	return app.states.Profile
+}
+
+// profileConfigs returns the vim configs of the active profile, or nil if
+// every config is active
+func (app *_appContext) profileConfigs() (map[string]bool, error) {
+	if app.states.Profile == "" {
+		return nil, nil
+	}
+	profiles, err := app.loadProfiles()
+	if err != nil {
+		return nil, err
+	}
+	names, ok := profiles.Profiles[app.states.Profile]
+	if !ok {
+		return nil, fmt.Errorf("active profile %s is not in %s", app.states.Profile, app.profilesFile())
+	}
+	configs := make(map[string]bool)
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".vimrc") {
+			name += ".vimrc"
+		}
+		configs[name] = true
+	}
+	return configs, nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-isatty"
+)
+
+// _GIT_PROGRESS_PATTERN matches the progress git prints with --progress, e.g.
+// 'Receiving objects:  45% (123/456), 1.20 MiB | 512.00 KiB/s'
+var _GIT_PROGRESS_PATTERN = regexp.MustCompile("^(?:remote: )?([A-Z][A-Za-z ]+):\\s+(\\d+)%")
+var _GIT_CLONING_PATTERN = regexp.MustCompile("^Cloning into (?:bare repository )?'(.+)'")
+
+// _GIT_PROGRESS_COMMANDS accept --progress right after the command
+var _GIT_PROGRESS_COMMANDS = map[string]bool{"clone": true, "fetch": true, "pull": true}
+
+var _SPINNER_FRAMES = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+const _PROGRESS_INTERVAL = 100 * time.Millisecond
+const _PROGRESS_STATUS_WIDTH = 60
+
+// progress shows the state of a clone, a download or a build. On a terminal
+// it's a single line redrawn with a spinner and the elapsed time, otherwise
+// it collapses to a verbose message each time the phase changes.
+type progress struct {
+	l       *logger
+	label   string
+	live    bool
+	started time.Time
+	status  string
+	phase   string
+	depth   int
+	partial []byte
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// startProgress starts the progress of a task, a task started inside another
+// one, e.g. the build of a plugin, updates the progress of the outer task
+func (l *logger) startProgress(label string) *progress {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if p := l.progress; p != nil {
+		p.depth++
+		p.setStatus(label)
+		return p
+	}
+	p := &progress{
+		l:       l,
+		label:   label,
+		live:    l.liveProgress(),
+		started: time.Now(),
+	}
+	l.progress = p
+	if p.live {
+		p.stop = make(chan struct{})
+		p.stopped = make(chan struct{})
+		go p.run()
+	}
+	return p
+}
+
+// liveProgress tells if the progress can be redrawn on stdout: a terminal in
+// text output, which isn't taken by the output of git and scripts
+func (l *logger) liveProgress() bool {
+	return isatty.IsTerminal(os.Stdout.Fd()) && os.Getenv("TERM") != "dumb" &&
+		!l.jsonOutput && !l.showOutput && l.level >= _LEVEL_NORMAL
+}
+
+func (p *progress) run() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(_PROGRESS_INTERVAL)
+	defer ticker.Stop()
+	for frame := 0; ; frame++ {
+		p.l.mu.Lock()
+		p.draw(frame)
+		p.l.mu.Unlock()
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// draw writes the progress line, the logger lock must be held
+func (p *progress) draw(frame int) {
+	status := p.status
+	if len(status) > _PROGRESS_STATUS_WIDTH {
+		status = status[:_PROGRESS_STATUS_WIDTH-3] + "..."
+	}
+	if status != "" {
+		status = ": " + status
+	}
+	elapsed := time.Since(p.started) / time.Second * time.Second
+	fmt.Fprintf(os.Stdout, "\r\033[K%s %s%s (%s)", _SPINNER_FRAMES[frame%len(_SPINNER_FRAMES)], p.label, status, elapsed)
+}
+
+// clearLine erases the progress line before a message is printed, it's
+// redrawn at the next tick; the logger lock must be held
+func (l *logger) clearLine() {
+	if l.progress != nil && l.progress.live {
+		fmt.Fprint(os.Stdout, "\r\033[K")
+	}
+}
+
+// update changes the status shown after the label
+func (p *progress) update(status string) {
+	p.l.mu.Lock()
+	p.setStatus(status)
+	p.l.mu.Unlock()
+}
+
+func (p *progress) setStatus(status string) {
+	p.status = status
+	if p.live {
+		return
+	}
+	// the numbers change within a phase
+	phase := status
+	if i := strings.IndexAny(status, "0123456789"); i > 0 {
+		phase = status[:i]
+	}
+	if phase != p.phase {
+		p.phase = phase
+		p.l.mu.Unlock()
+		p.l.verbose("%s: %s", p.label, status)
+		p.l.mu.Lock()
+	}
+}
+
+// finish ends the task, the line is erased and the outcome is left to the
+// messages of the caller
+func (p *progress) finish() {
+	p.l.mu.Lock()
+	if p.depth > 0 {
+		p.depth--
+		p.l.mu.Unlock()
+		return
+	}
+	p.l.clearLine()
+	p.l.progress = nil
+	p.l.mu.Unlock()
+	if p.live {
+		close(p.stop)
+		<-p.stopped
+		p.l.mu.Lock()
+		fmt.Fprint(os.Stdout, "\r\033[K")
+		p.l.mu.Unlock()
+	}
+	p.l.verbose("%s: done in %s", p.label, time.Since(p.started)/time.Millisecond*time.Millisecond)
+}
+
+// Write parses the stderr of git, the lines are separated by '\r' while an
+// operation is in progress
+func (p *progress) Write(data []byte) (int, error) {
+	p.partial = append(p.partial, data...)
+	for {
+		i := strings.IndexAny(string(p.partial), "\r\n")
+		if i < 0 {
+			return len(data), nil
+		}
+		line := strings.TrimSpace(string(p.partial[:i]))
+		p.partial = p.partial[i+1:]
+		switch ss := _GIT_PROGRESS_PATTERN.FindStringSubmatch(line); {
+		case ss != nil:
+			p.update(strings.ToLower(ss[1]) + " " + ss[2] + "%")
+		case _GIT_CLONING_PATTERN.MatchString(line):
+			p.update("cloning " + path.Base(_GIT_CLONING_PATTERN.FindStringSubmatch(line)[1]))
+		case line != "":
+			p.l.debug("git: %s", line)
+		}
+	}
+}
+
+// gitStderr records the first error of a git command while its stderr goes
+// on to the progress, the terminal or nowhere
+type gitStderr struct {
+	l       *logger
+	next    io.Writer
+	partial []byte
+}
+
+func (w *gitStderr) Write(data []byte) (int, error) {
+	if w.next != nil {
+		if _, err := w.next.Write(data); err != nil {
+			return 0, err
+		}
+	}
+	w.partial = append(w.partial, data...)
+	for {
+		i := strings.IndexAny(string(w.partial), "\r\n")
+		if i < 0 {
+			return len(data), nil
+		}
+		line := strings.TrimSpace(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+		if strings.HasPrefix(line, "fatal: ") || strings.HasPrefix(line, "error: ") {
+			w.l.mu.Lock()
+			if w.l.gitErr == "" {
+				w.l.gitErr = line
+			}
+			w.l.mu.Unlock()
+		}
+	}
+}
+
+// gitError adds the first error reported by the last git command to its
+// error, which is only an exit status
+func (l *logger) gitError(err error) error {
+	if _, ok := err.(*exec.ExitError); !ok || l.gitErr == "" {
+		return err
+	}
+	return fmt.Errorf("%s (%s)", l.gitErr, err)
+}
+
+// gitProgressArgs adds --progress to the git commands which report it, git
+// only reports it to a terminal otherwise
+func gitProgressArgs(args []string) []string {
+	i := 0
+	for i+1 < len(args) && args[i] == "-c" {
+		i += 2
+	}
+	if i >= len(args) {
+		return args
+	}
+	at := -1
+	switch {
+	case _GIT_PROGRESS_COMMANDS[args[i]]:
+		at = i + 1
+	case args[i] == "submodule" && i+1 < len(args) && args[i+1] == "update":
+		at = i + 2
+	}
+	if at < 0 {
+		return args
+	}
+	return append(append(append([]string{}, args[:at]...), "--progress"), args[at:]...)
+}
+
+// progressReader reports the bytes read from a download
+type progressReader struct {
+	io.Reader
+	p     *progress
+	read  int64
+	total int64
+	shown time.Time
+}
+
+// trackDownload counts the bytes of a download in the current progress, if
+// any; total is negative when it's unknown
+func (l *logger) trackDownload(r io.Reader, total int64) io.Reader {
+	if l.progress == nil {
+		return r
+	}
+	return &progressReader{Reader: r, p: l.progress, total: total}
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.read += int64(n)
+	if time.Since(r.shown) >= _PROGRESS_INTERVAL || err != nil {
+		r.shown = time.Now()
+		if r.total > 0 {
+			r.p.update(fmt.Sprintf("downloaded %s of %s %d%%", formatSize(r.read), formatSize(r.total), r.read*100/r.total))
+		} else {
+			r.p.update("downloaded " + formatSize(r.read))
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// _DEFAULT_GIT_HOST is where the 'owner/repo' shorthand points to
+const _DEFAULT_GIT_HOST = "github.com"
+
+// _HOST_ALIASES are the builtin prefixes of 'alias:owner/repo', settings.yml
+// can add more or override them
+var _HOST_ALIASES = map[string]string{
+	"gh": "https://github.com/",
+	"gl": "https://gitlab.com/",
+	"bb": "https://bitbucket.org/",
+	"sr": "https://git.sr.ht/~",
+}
+
+var _URL_SCHEME_PATTERN = regexp.MustCompile("^([A-Za-z][A-Za-z0-9+.-]*)://")
+var _HOST_ALIAS_PATTERN = regexp.MustCompile("^([A-Za-z][A-Za-z0-9_-]*):([^/].*)$")
+var _GIT_SSH_PATTERN = regexp.MustCompile("^[^@/:]+@([^/:]+):(.+)$")
+var _GIT_SSH_HOST_PATTERN = regexp.MustCompile("^[^@/:]+@[^/:]+:")
+var _GIT_HOST_PATH_PATTERN = regexp.MustCompile("^([^/]+\\.[^/]+)/(.+)$")
+var _GIT_SHORTHAND_PATTERN = regexp.MustCompile("^[A-Za-z0-9_.~-]+/[A-Za-z0-9_.-]+$")
+
+// urlRewrite replaces the prefix of the resolved urls, like the insteadOf of
+// git config, e.g. to use ssh for the repositories of an organization
+type urlRewrite struct {
+	Match   string `yaml:"match"`
+	Replace string `yaml:"replace"`
+}
+
+// urlResolver turns the plugin names written in vim configs, plugins.yml and
+// the command line into git urls:
+//
+//	owner/repo                 https://<default host>/owner/repo
+//	gh:owner/repo              https://github.com/owner/repo
+//	git.corp.example/team/repo https://git.corp.example/team/repo
+//	git@host:owner/repo.git    unchanged, as any url with a scheme
+//	./repo, ~/repo, /path/repo file:///path/repo
+type urlResolver struct {
+	defaultHost string
+	aliases     map[string]string
+	rewrites    []urlRewrite
+	home        string
+	workDir     string
+}
+
+// _resolver is used by getPluginNameFromUrl, it's configured by settings.yml
+// when the application context is created
+var _resolver = newURLResolver(nil)
+
+func newURLResolver(settings *vimSettings) *urlResolver {
+	r := &urlResolver{
+		defaultHost: _DEFAULT_GIT_HOST,
+		aliases:     make(map[string]string),
+	}
+	for alias, prefix := range _HOST_ALIASES {
+		r.aliases[alias] = prefix
+	}
+	if u, err := user.Current(); err == nil {
+		r.home = u.HomeDir
+	}
+	r.workDir, _ = os.Getwd()
+	if settings == nil {
+		return r
+	}
+	if settings.DefaultHost != "" {
+		r.defaultHost = settings.DefaultHost
+	}
+	for alias, prefix := range settings.Hosts {
+		r.aliases[alias] = prefix
+	}
+	r.rewrites = settings.Rewrites
+	return r
+}
+
+// resolve returns the bundle name and the git url of a plugin, the name is
+// empty if it's not a url, so it has to be searched on vimawesome
+func (r *urlResolver) resolve(plugin string) (string, string) {
+	url := r.expand(strings.TrimSpace(plugin))
+	if url == "" {
+		return "", ""
+	}
+	url = r.rewrite(url)
+
+	repoPath := url
+	if ss := _URL_SCHEME_PATTERN.FindStringSubmatch(url); len(ss) > 0 {
+		repoPath = url[len(ss[0]):]
+	} else if ss := _GIT_SSH_PATTERN.FindStringSubmatch(url); len(ss) > 0 {
+		repoPath = ss[2]
+	}
+	// only the downloads lose their suffix, a repository may well be named
+	// like a file, e.g. fzf.vim
+	name := strings.TrimSuffix(path.Base(strings.TrimRight(repoPath, "/")), ".git")
+	if isArchiveURL(url) {
+		name = archiveName(url)
+	}
+	if name == "" || name == "." || name == "/" {
+		return "", ""
+	}
+	return name, url
+}
+
+// expand converts the forms accepted in plugin names to a git url
+func (r *urlResolver) expand(plugin string) string {
+	switch {
+	case plugin == "":
+		return ""
+	case _URL_SCHEME_PATTERN.MatchString(plugin):
+		return strings.TrimRight(plugin, "/")
+	case _GIT_SSH_PATTERN.MatchString(plugin):
+		return plugin
+	case plugin == "~" || strings.HasPrefix(plugin, "~/"):
+		return "file://" + path.Join(r.home, plugin[1:])
+	case path.IsAbs(plugin):
+		return "file://" + path.Clean(plugin)
+	case plugin == "." || plugin == ".." || strings.HasPrefix(plugin, "./") || strings.HasPrefix(plugin, "../"):
+		return "file://" + filepath.Join(r.workDir, plugin)
+	}
+
+	if ss := _HOST_ALIAS_PATTERN.FindStringSubmatch(plugin); len(ss) > 0 {
+		if prefix, ok := r.aliases[ss[1]]; ok {
+			repo := ss[2]
+			if strings.HasSuffix(prefix, "~") {
+				// sourcehut users are written with or without their ~
+				repo = strings.TrimPrefix(repo, "~")
+			}
+			return strings.TrimRight(r.hostURL(prefix)+repo, "/")
+		}
+		return ""
+	}
+	if ss := _GIT_HOST_PATH_PATTERN.FindStringSubmatch(plugin); len(ss) > 0 {
+		return "https://" + strings.TrimRight(plugin, "/")
+	}
+	if _GIT_SHORTHAND_PATTERN.MatchString(plugin) {
+		host := r.hostURL(r.defaultHost)
+		if !strings.HasSuffix(host, ":") {
+			host = strings.TrimRight(host, "/") + "/"
+		}
+		return host + plugin
+	}
+	return ""
+}
+
+// hostURL adds https:// to a host without scheme, an ssh host is written
+// 'git@host:'
+func (r *urlResolver) hostURL(host string) string {
+	if _URL_SCHEME_PATTERN.MatchString(host) || _GIT_SSH_HOST_PATTERN.MatchString(host) {
+		return host
+	}
+	return "https://" + host
+}
+
+// rewrite applies the first rewrite rule matching the url
+func (r *urlResolver) rewrite(url string) string {
+	for _, rule := range r.rewrites {
+		if rule.Match != "" && strings.HasPrefix(url, rule.Match) {
+			return rule.Replace + strings.TrimPrefix(url, rule.Match)
+		}
+	}
+	return url
+}
+
+func getPluginNameFromUrl(url string) (string, string) {
+	return _resolver.resolve(url)
+}
@@ -0,0 +1,105 @@
+package main
+
+import "testing"
+
+func TestResolve(t *testing.T) {
+	for _, tc := range []struct {
+		desc     string
+		settings *vimSettings
+		plugin   string
+		name     string
+		url      string
+	}{
+		// owner/repo shorthand
+		{"shorthand", nil, "tpope/vim-fugitive", "vim-fugitive", "https://github.com/tpope/vim-fugitive"},
+		{"shorthand with dots", nil, "junegunn/fzf.vim", "fzf.vim", "https://github.com/junegunn/fzf.vim"},
+		{"shorthand with .git", nil, "tpope/vim-fugitive.git", "vim-fugitive", "https://github.com/tpope/vim-fugitive.git"},
+		{"surrounding spaces", nil, "  tpope/vim-fugitive ", "vim-fugitive", "https://github.com/tpope/vim-fugitive"},
+
+		// default_host
+		{"default host", &vimSettings{DefaultHost: "gitlab.com"}, "a/b", "b", "https://gitlab.com/a/b"},
+		{"default host with slash", &vimSettings{DefaultHost: "git.corp.example/"}, "a/b", "b", "https://git.corp.example/a/b"},
+		{"default host with scheme", &vimSettings{DefaultHost: "http://git.lan"}, "a/b", "b", "http://git.lan/a/b"},
+		{"default host over ssh", &vimSettings{DefaultHost: "git@git.corp.example:"}, "a/b", "b", "git@git.corp.example:a/b"},
+
+		// builtin aliases
+		{"gh alias", nil, "gh:tpope/vim-fugitive", "vim-fugitive", "https://github.com/tpope/vim-fugitive"},
+		{"gl alias", nil, "gl:a/b.git", "b", "https://gitlab.com/a/b.git"},
+		{"bb alias", nil, "bb:a/b", "b", "https://bitbucket.org/a/b"},
+		{"sr alias", nil, "sr:sircmpwn/x", "x", "https://git.sr.ht/~sircmpwn/x"},
+		{"sr alias with ~", nil, "sr:~sircmpwn/x", "x", "https://git.sr.ht/~sircmpwn/x"},
+		{"unknown alias", nil, "xx:a/b", "", ""},
+
+		// custom hosts
+		{"custom alias", &vimSettings{Hosts: map[string]string{"corp": "git.corp.example/"}}, "corp:team/x", "x", "https://git.corp.example/team/x"},
+		{"custom alias over ssh", &vimSettings{Hosts: map[string]string{"corp": "git@git.corp.example:"}}, "corp:team/x", "x", "git@git.corp.example:team/x"},
+		{"overridden alias", &vimSettings{Hosts: map[string]string{"gh": "https://github.corp.example/"}}, "gh:a/b", "b", "https://github.corp.example/a/b"},
+
+		// internal hosts
+		{"host path", nil, "git.corp.example/team/x", "x", "https://git.corp.example/team/x"},
+		{"host path with groups", nil, "git.corp.example/team/sub/x", "x", "https://git.corp.example/team/sub/x"},
+		{"host path with port", nil, "git.corp.example:8443/team/x", "x", "https://git.corp.example:8443/team/x"},
+
+		// urls with a scheme
+		{"https", nil, "https://github.com/a/b/", "b", "https://github.com/a/b"},
+		{"http with .git", nil, "http://example.org/b.git", "b", "http://example.org/b.git"},
+		{"ssh url", nil, "ssh://git@host:22/a/b.git", "b", "ssh://git@host:22/a/b.git"},
+
+		// scp-like ssh
+		{"ssh with .git", nil, "git@github.com:a/b.git", "b", "git@github.com:a/b.git"},
+		{"ssh without .git", nil, "git@github.com:a/b", "b", "git@github.com:a/b"},
+		{"ssh to internal host", nil, "git@git.corp.example:team/sub/x", "x", "git@git.corp.example:team/sub/x"},
+
+		// local repositories
+		{"file url", nil, "file:///src/b", "b", "file:///src/b"},
+		{"absolute path", nil, "/src/b", "b", "file:///src/b"},
+		{"absolute path to clean", nil, "/src/./a/../b/", "b", "file:///src/b"},
+		{"home", nil, "~/src/b", "b", "file:///home/u/src/b"},
+		{"relative path", nil, "./b", "b", "file:///work/b"},
+		{"parent path", nil, "../b", "b", "file:///b"},
+
+		// rewrite rules
+		{"rewrite to ssh", &vimSettings{Rewrites: []urlRewrite{{Match: "https://github.com/myorg/", Replace: "git@github.com:myorg/"}}}, "myorg/tool", "tool", "git@github.com:myorg/tool"},
+		{"rewrite of another org", &vimSettings{Rewrites: []urlRewrite{{Match: "https://github.com/myorg/", Replace: "git@github.com:myorg/"}}}, "tpope/x", "x", "https://github.com/tpope/x"},
+		{"first rewrite wins", &vimSettings{Rewrites: []urlRewrite{{Match: "https://github.com/", Replace: "https://a.example/"}, {Match: "https://github.com/", Replace: "https://b.example/"}}}, "gh:o/x", "x", "https://a.example/o/x"},
+		{"rewrite of a full url", &vimSettings{Rewrites: []urlRewrite{{Match: "https://gitlab.com/", Replace: "https://gitlab.corp.example/"}}}, "https://gitlab.com/a/b", "b", "https://gitlab.corp.example/a/b"},
+
+		// downloads
+		{"archive", nil, "https://corp.example/rel/x-1.2.tar.gz", "x-1.2", "https://corp.example/rel/x-1.2.tar.gz"},
+		{"zip", nil, "https://corp.example/rel/x.zip", "x", "https://corp.example/rel/x.zip"},
+		{"raw script", nil, "https://raw.githubusercontent.com/a/b/master/plugin/x.vim", "x", "https://raw.githubusercontent.com/a/b/master/plugin/x.vim"},
+		{"vim.org script", nil, "https://www.vim.org/scripts/download_script.php?src_id=123", "vim-script-123", "https://www.vim.org/scripts/download_script.php?src_id=123"},
+
+		// names searched on vimawesome
+		{"single word", nil, "nerdtree", "", ""},
+		{"single word with dots", nil, "fzf.vim", "", ""},
+		{"empty", nil, "", "", ""},
+		{"blank", nil, "   ", "", ""},
+	} {
+		r := newURLResolver(tc.settings)
+		r.home, r.workDir = "/home/u", "/work"
+		name, url := r.resolve(tc.plugin)
+		if name != tc.name || url != tc.url {
+			t.Errorf("%s: resolve(%q) = %q, %q, want %q, %q", tc.desc, tc.plugin, name, url, tc.name, tc.url)
+		}
+	}
+}
+
+func TestResolveArchiveURL(t *testing.T) {
+	for _, tc := range []struct {
+		url     string
+		archive bool
+	}{
+		{"https://github.com/junegunn/fzf.vim", false},
+		{"https://github.com/a/b.git", false},
+		{"git@github.com:a/b.zip", false},
+		{"file:///src/x.tar.gz", false},
+		{"https://corp.example/x.tgz", true},
+		{"https://raw.githubusercontent.com/a/b/master/x.vim", true},
+		{"https://www.vim.org/scripts/download_script.php?src_id=1", true},
+	} {
+		if archive := isArchiveURL(tc.url); archive != tc.archive {
+			t.Errorf("isArchiveURL(%q) = %v, want %v", tc.url, archive, tc.archive)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"regexp"
+	"time"
+)
+
+const (
+	_DEFAULT_RETRY_ATTEMPTS  = 3
+	_DEFAULT_RETRY_DELAY     = 2 * time.Second
+	_DEFAULT_RETRY_MAX_DELAY = 30 * time.Second
+)
+
+// _PERMANENT_ERROR_PATTERN matches the errors retrying can't fix, they win
+// over the retryable ones
+var _PERMANENT_ERROR_PATTERN = regexp.MustCompile("(?i)not found|does not appear to be a git repository|does not exist|" +
+	"authentication failed|permission denied|could not read (username|password)|invalid (username|password)|" +
+	"couldn't find remote ref|did not match any|returned error: 4(0[0-9]|1[0-9]|2[0-8])")
+
+// _RETRYABLE_ERROR_PATTERN matches the transient network errors of git and
+// the http client
+var _RETRYABLE_ERROR_PATTERN = regexp.MustCompile("(?i)could not resolve host|temporary failure in name resolution|" +
+	"connection (timed out|reset|refused|closed)|operation timed out|i/o timeout|timed out|no route to host|" +
+	"network is unreachable|failed to connect|early eof|unexpected eof|the remote end hung up|rpc failed|" +
+	"unexpected disconnect|tls handshake|gnutls|ssl_error|returned error: (5[0-9][0-9]|429)")
+
+// retrySettings are the 'retry' of settings.yml:
+//
+//	retry:
+//	  attempts: 5
+//	  delay: 1s
+//	  max_delay: 1m
+type retrySettings struct {
+	Attempts int           `yaml:"attempts,omitempty"`
+	Delay    time.Duration `yaml:"delay,omitempty"`
+	MaxDelay time.Duration `yaml:"max_delay,omitempty"`
+}
+
+// httpStatusError is a download answered by an http error
+type httpStatusError struct {
+	status string
+	code   int
+}
+
+func (e *httpStatusError) Error() string {
+	return e.status
+}
+
+// retryable tells if an error is a transient network failure, the unknown
+// ones are permanent
+func retryable(err error) bool {
+	var statusErr *httpStatusError
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	switch {
+	case err == nil, errors.Is(err, errOffline):
+		return false
+	case errors.As(err, &statusErr):
+		return statusErr.code >= 500 || statusErr.code == 429
+	case _PERMANENT_ERROR_PATTERN.MatchString(err.Error()):
+		return false
+	case errors.As(err, &dnsErr), errors.As(err, &netErr) && netErr.Timeout():
+		return true
+	}
+	return _RETRYABLE_ERROR_PATTERN.MatchString(err.Error())
+}
+
+// retry runs fn until it succeeds, fails with a permanent error or runs out of
+// attempts; cleanup, if any, removes what a failed attempt left behind before
+// the next one. The delay doubles after each attempt, with jitter.
+func (app *_appContext) retry(what string, cleanup func(), fn func() error) error {
+	attempts, delay, maxDelay := app.settings.Retry.Attempts, app.settings.Retry.Delay, app.settings.Retry.MaxDelay
+	if attempts <= 0 {
+		attempts = _DEFAULT_RETRY_ATTEMPTS
+	}
+	if delay <= 0 {
+		delay = _DEFAULT_RETRY_DELAY
+	}
+	if maxDelay <= 0 {
+		maxDelay = _DEFAULT_RETRY_MAX_DELAY
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= attempts || !retryable(err) {
+			return err
+		}
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		app.warn("%s failed (%s), retry in %s (%d/%d)", what, err, wait.Round(100*time.Millisecond), attempt, attempts-1)
+		if app.progress != nil {
+			app.progress.update(fmt.Sprintf("retry %d/%d", attempt, attempts-1))
+		}
+		time.Sleep(wait)
+		if cleanup != nil {
+			cleanup()
+		}
+		if delay *= 2; delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testTimeoutError is a net.Error which timed out
+type testTimeoutError struct{}
+
+func (testTimeoutError) Error() string   { return "deadline exceeded" }
+func (testTimeoutError) Timeout() bool   { return true }
+func (testTimeoutError) Temporary() bool { return true }
+
+func TestRetryable(t *testing.T) {
+	for _, tc := range []struct {
+		desc      string
+		err       error
+		retryable bool
+	}{
+		{"no error", nil, false},
+		{"offline", errOffline, false},
+		{"wrapped offline", fmt.Errorf("plugin x: %w", errOffline), false},
+
+		// network
+		{"dns failure", &net.DNSError{Err: "no such host", Name: "github.com"}, true},
+		{"wrapped dns failure", &url.Error{Op: "Get", URL: "https://x", Err: &net.DNSError{Err: "server misbehaving", Name: "x"}}, true},
+		{"git dns failure", errors.New("fatal: unable to access 'https://github.com/a/b/': Could not resolve host: github.com (exit status 128)"), true},
+		{"connection reset", &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, true},
+		{"git connection reset", errors.New("error: RPC failed; curl 56 Recv failure: Connection reset by peer (exit status 128)"), true},
+		{"timeout", &url.Error{Op: "Get", URL: "https://x", Err: testTimeoutError{}}, true},
+		{"hung up", errors.New("fatal: the remote end hung up unexpectedly (exit status 128)"), true},
+
+		// http
+		{"500", &httpStatusError{"500 Internal Server Error", 500}, true},
+		{"503", &httpStatusError{"503 Service Unavailable", 503}, true},
+		{"429", &httpStatusError{"429 Too Many Requests", 429}, true},
+		{"404", &httpStatusError{"404 Not Found", 404}, false},
+		{"403", &httpStatusError{"403 Forbidden", 403}, false},
+		{"wrapped 502", fmt.Errorf("download x: %w", &httpStatusError{"502 Bad Gateway", 502}), true},
+		{"git 502", errors.New("fatal: unable to access 'https://x/': The requested URL returned error: 502 (exit status 128)"), true},
+		{"git 429", errors.New("fatal: unable to access 'https://x/': The requested URL returned error: 429 (exit status 128)"), true},
+		{"git 403", errors.New("fatal: unable to access 'https://x/': The requested URL returned error: 403 (exit status 128)"), false},
+
+		// permanent
+		{"repository not found", errors.New("remote: Repository not found.\nfatal: repository 'https://github.com/a/b/' not found (exit status 128)"), false},
+		{"not a repository", errors.New("fatal: 'x' does not appear to be a git repository (exit status 128)"), false},
+		{"authentication failure", errors.New("fatal: Authentication failed for 'https://x/' (exit status 128)"), false},
+		{"no credentials", errors.New("fatal: could not read Username for 'https://x': terminal prompts disabled (exit status 128)"), false},
+		{"ssh key", errors.New("git@github.com: Permission denied (publickey).\nfatal: Could not read from remote repository. (exit status 128)"), false},
+		{"unknown ref", errors.New("error: pathspec 'v9' did not match any file(s) known to git (exit status 1)"), false},
+		{"permanent wins", errors.New("fatal: repository not found, connection reset"), false},
+
+		// unknown
+		{"bare exit status 128", errors.New("exit status 128"), false},
+		{"bare exit status 1", errors.New("exit status 1"), false},
+	} {
+		if retryable := retryable(tc.err); retryable != tc.retryable {
+			t.Errorf("%s: retryable(%v) = %v, want %v", tc.desc, tc.err, retryable, tc.retryable)
+		}
+	}
+}
+
+func TestRetryAttempts(t *testing.T) {
+	app := &_appContext{logger: newLogger(_LEVEL_QUIET), settings: &vimSettings{Retry: retrySettings{Attempts: 3, Delay: time.Millisecond}}}
+	calls, cleanups := 0, 0
+	err := app.retry("x", func() { cleanups++ }, func() error {
+		calls++
+		return errors.New("connection reset by peer")
+	})
+	if err == nil || calls != 3 || cleanups != 2 {
+		t.Errorf("transient error: %d call(s), %d cleanup(s), error %v", calls, cleanups, err)
+	}
+
+	calls = 0
+	app.retry("x", nil, func() error {
+		calls++
+		return errors.New("repository not found")
+	})
+	if calls != 1 {
+		t.Errorf("permanent error: %d call(s)", calls)
+	}
+
+	calls = 0
+	err = app.retry("x", nil, func() error {
+		if calls++; calls < 2 {
+			return errors.New("early EOF")
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("recovered error: %d call(s), error %v", calls, err)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/mattn/go-isatty"
+)
+
+// _DEFAULT_SCRIPT_TIMEOUT limits the scripts in restricted mode
+const _DEFAULT_SCRIPT_TIMEOUT = 10 * time.Minute
+
+// _DEFAULT_TRUSTED_LAYERS run their scripts without approval: the configs
+// shipped with this tool and the ones written by the user
+var _DEFAULT_TRUSTED_LAYERS = []string{"embedded", "user"}
+
+// _RESTRICTED_ENV are the variables kept for the scripts in restricted mode
+var _RESTRICTED_ENV = []string{"PATH", "HOME", "USER", "LANG", "LC_ALL", "TERM", "TMPDIR"}
+
+var errScriptNotApproved = errors.New("script is not approved")
+
+// scriptSettings are the 'scripts' of settings.yml:
+//
+//	scripts:
+//	  trusted_layers: [embedded, user, source team]
+//	  restricted: true
+//	  timeout: 5m
+type scriptSettings struct {
+	TrustedLayers []string      `yaml:"trusted_layers,omitempty"`
+	Restricted    bool          `yaml:"restricted,omitempty"`
+	Timeout       time.Duration `yaml:"timeout,omitempty"`
+}
+
+// scriptApproval records that the user reviewed a script, by its md5
+type scriptApproval struct {
+	Config     string    `yaml:"config"`
+	ApprovedAt time.Time `yaml:"approved_at"`
+}
+
+var scriptsCommand = cli.Command{
+	Name:  "scripts",
+	Usage: "review and approve the '@run-script' scripts of vim configs",
+	Subcommands: []cli.Command{
+		{
+			Name:    "list",
+			Usage:   "list the scripts of vim configs and whether they may run",
+			Aliases: []string{"ls"},
+			Action: func(c *cli.Context) {
+				app := getApp(c)
+				configs, err := app.parseVimConfigs()
+				if err != nil {
+					app.fatal("unable to read vim configs (error: %s)", err)
+				}
+				if !app.jsonOutput {
+					fmt.Println("List scripts:")
+				}
+				for _, config := range configs {
+					for _, script := range config.scripts() {
+						hash := scriptHash(script)
+						if app.jsonOutput {
+							app.event("script", eventFields{"config": config.name, "hash": hash, "status": app.scriptStatus(config.name, hash)})
+							continue
+						}
+						fmt.Printf("  %-24s %s  %s\n", config.name, hash[:8], app.scriptStatus(config.name, hash))
+					}
+				}
+			},
+		},
+		{
+			Name:      "show",
+			Usage:     "print the scripts of vim config(s)",
+			ArgsUsage: "<name> [<name> ...]",
+			Action: func(c *cli.Context) {
+				app := getApp(c)
+				for _, name := range configArgs(app, c) {
+					config := app.findVimConfig(name)
+					for _, script := range config.scripts() {
+						hash := scriptHash(script)
+						if app.jsonOutput {
+							app.event("script", eventFields{"config": name, "hash": hash, "status": app.scriptStatus(name, hash), "script": script})
+							continue
+						}
+						fmt.Printf("# %s@%s (%s)\n%s\n", name, hash[:8], app.scriptStatus(name, hash), script)
+					}
+				}
+			},
+		},
+		{
+			Name:      "approve",
+			Usage:     "approve the current scripts of vim config(s)",
+			ArgsUsage: "<name> [<name> ...]",
+			Action: func(c *cli.Context) {
+				app, done := beginUpdate(c)
+				defer done()
+				for _, name := range configArgs(app, c) {
+					config := app.findVimConfig(name)
+					for _, script := range config.scripts() {
+						app.approveScript(name, scriptHash(script))
+					}
+					app.success("scripts of %s are approved", name)
+				}
+			},
+		},
+	},
+}
+
+func scriptHash(script string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(script)))
+}
+
+// scripts returns the bodies of the '@run-script' directives
+func (config *vimConfig) scripts() []string {
+	scripts := []string{}
+	for _, d := range config.directives {
+		if d.kind == _DIRECTIVE_SCRIPT {
+			scripts = append(scripts, d.value)
+		}
+	}
+	return scripts
+}
+
+func (app *_appContext) findVimConfig(name string) *vimConfig {
+	resolved := app.resolveConfigs()[name]
+	if resolved == nil || resolved.path == "" {
+		app.fatal("no such vim config: %s", name)
+	}
+	config, err := parseVimConfig(resolved.path)
+	if err != nil {
+		app.fatal("unable to read %s (error: %s)", resolved.path, err)
+	}
+	return config
+}
+
+// scriptLayer returns the config layer which provides the script, the build
+// commands of plugins.yml belong to the user
+func (app *_appContext) scriptLayer(configName string) string {
+	if strings.HasPrefix(configName, "plugins.yml:") {
+		return "user"
+	}
+	if resolved := app.resolveConfigs()[configName]; resolved != nil {
+		return resolved.layer
+	}
+	return ""
+}
+
+func (app *_appContext) isTrustedLayer(layer string) bool {
+	trusted := app.settings.Scripts.TrustedLayers
+	if trusted == nil {
+		trusted = _DEFAULT_TRUSTED_LAYERS
+	}
+	for _, l := range trusted {
+		if l == layer {
+			return true
+		}
+	}
+	return false
+}
+
+func (app *_appContext) scriptStatus(configName, hash string) string {
+	layer := app.scriptLayer(configName)
+	switch {
+	case app.isTrustedLayer(layer):
+		return "trusted (" + layer + " layer)"
+	case app.states.Approvals[hash] != nil:
+		return "approved " + app.states.Approvals[hash].ApprovedAt.Format("2006-01-02")
+	}
+	return "needs approval (" + layer + " layer)"
+}
+
+func (app *_appContext) approveScript(configName, hash string) {
+	app.states.Approvals[hash] = &scriptApproval{Config: configName, ApprovedAt: time.Now()}
+}
+
+// checkScriptApproval lets a script run if its layer is trusted or its hash
+// is approved, otherwise the user is asked when there is a terminal
+func (app *_appContext) checkScriptApproval(configName, hash, script string) error {
+	layer := app.scriptLayer(configName)
+	if app.isTrustedLayer(layer) || app.states.Approvals[hash] != nil {
+		return nil
+	}
+	if app.jsonOutput || !isatty.IsTerminal(os.Stdin.Fd()) {
+		app.err("script %s@%s from %s layer is not approved, review it with '%s scripts show %s' and run '%s scripts approve %s'",
+			configName, hash[:8], layer, app.cmdName, configName, app.cmdName, configName)
+		return errScriptNotApproved
+	}
+
+	fmt.Printf("The script of %s (%s layer) is new or changed:\n", configName, layer)
+	for _, line := range splitLines([]byte(script)) {
+		fmt.Println("  | " + line)
+	}
+	fmt.Print("Run it? [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+		app.warn("skip the script of %s", configName)
+		return errScriptNotApproved
+	}
+	app.approveScript(configName, hash)
+	return nil
+}
+
+// scriptCommand prepares bash to run a script file. In restricted mode the
+// script gets a scrubbed environment, no stdin and its own working directory.
+func (app *_appContext) scriptCommand(scriptFile, workDir string) *exec.Cmd {
+	cmd := exec.Command("/bin/bash", scriptFile)
+	env := []string{
+		"HOST_OS=" + runtime.GOOS,
+		"HOST_ARCH=" + runtime.GOARCH,
+		"VIMDIR=" + path.Dir(app.bundleDir),
+	}
+	if !app.settings.Scripts.Restricted {
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Stdin = os.Stdin
+		return cmd
+	}
+
+	for _, name := range _RESTRICTED_ENV {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	cmd.Env = env
+	if workDir == "" {
+		workDir = scriptFile + ".d"
+		os.MkdirAll(workDir, 0755)
+	}
+	cmd.Dir = workDir
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
+
+// runScriptCommand runs a script, in restricted mode it's killed with its
+// children once the timeout is over
+func (app *_appContext) runScriptCommand(cmd *exec.Cmd) error {
+	if !app.settings.Scripts.Restricted {
+		return cmd.Run()
+	}
+	timeout := app.settings.Scripts.Timeout
+	if timeout <= 0 {
+		timeout = _DEFAULT_SCRIPT_TIMEOUT
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	timer := time.AfterFunc(timeout, func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	})
+	err := cmd.Wait()
+	if !timer.Stop() {
+		return fmt.Errorf("killed after %s", timeout)
+	}
+	return err
+}
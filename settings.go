@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+
+	"gopkg.in/yaml.v2"
+)
+
+// vimSettings are the preferences read from settings.yml in the vim dir:
+//
+//	default_host: git.corp.example
+//	hosts:
+//	  corp: https://git.corp.example/
+//	rewrites:
+//	  - match: https://github.com/myorg/
+//	    replace: git@github.com:myorg/
+type vimSettings struct {
+	DefaultHost string            `yaml:"default_host,omitempty"`
+	Hosts       map[string]string `yaml:"hosts,omitempty"`
+	Rewrites    []urlRewrite      `yaml:"rewrites,omitempty"`
+	Credentials []hostCredential  `yaml:"credentials,omitempty"`
+	Scripts     scriptSettings    `yaml:"scripts,omitempty"`
+	Retry       retrySettings     `yaml:"retry,omitempty"`
+	Network     networkSettings   `yaml:"network,omitempty"`
+}
+
+func (app *_appContext) settingsFile() string {
+	return path.Join(app.vimDir, "settings.yml")
+}
+
+// loadSettings reads settings.yml, a missing file means the defaults
+func (app *_appContext) loadSettings() error {
+	app.settings = &vimSettings{}
+	data, err := ioutil.ReadFile(app.settingsFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return yaml.Unmarshal(data, app.settings)
+}
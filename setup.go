@@ -8,15 +8,14 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"os/exec"
 	"os/user"
 	"path"
 	"regexp"
-	"runtime"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/codegangsta/cli"
 	"github.com/ungerik/go-dry"
@@ -56,31 +55,40 @@ const _PATHOGEN_VIM_URL = "https://raw.githubusercontent.com/tpope/vim-pathogen/
 
 var _PATHOGEN_C_PATTERN = regexp.MustCompile("^\\s*exec(?:ute|)\\s+pathogen#.*")
 
-func (app *_appContext) setupVimPlugins(c *cli.Context) error {
-	app.vimDir = c.GlobalString("vimdir")
-	app.vimrcPath = c.GlobalString("vimrc")
-	app.bundleDir = path.Join(app.vimDir, "bundle")
-	app.autoloadDir = path.Join(app.vimDir, "autoload")
-	app.configDir = path.Join(app.vimDir, "configs")
-	app.tmpDir = path.Join(app.vimDir, "tmp")
-	app.cmdName = path.Base(os.Args[0])
-
-	app.info("start to check and setup vim ...")
+var setupCommand = cli.Command{
+	Name:    "setup",
+	Usage:   "install plugins required by vim configs and regenerate .vimrc",
+	Aliases: []string{"sync"},
+	Action: func(c *cli.Context) {
+		getApp(c).checkPrerequisites()
+		app, done := beginUpdate(c)
+		defer done()
+		if err := app.setupVimPlugins(); err != nil {
+			app.failCommand(err)
+		}
+	},
+}
 
+// prepareVimDirs creates the vim directories and a fresh tmp dir, the returned
+// function removes the tmp dir again
+func (app *_appContext) prepareVimDirs() func() {
 	os.MkdirAll(app.bundleDir, 0755)
 	os.MkdirAll(app.autoloadDir, 0755)
 	os.MkdirAll(app.configDir, 0755)
 	os.RemoveAll(app.tmpDir)
 	os.MkdirAll(app.tmpDir, 0755)
-	defer os.RemoveAll(app.tmpDir)
+	return func() {
+		os.RemoveAll(app.tmpDir)
+	}
+}
+
+func (app *_appContext) setupVimPlugins() error {
+	app.info("start to check and setup vim ...")
 
 	app.vimrcBuf = bytes.NewBuffer([]byte{})
 	app.oldVimrcBuf = bytes.NewBuffer([]byte{})
 	app.generatedVimrc = true
 
-	app.loadStates()
-	defer app.saveStates()
-
 	if dry.FileExists(app.vimrcPath) {
 		oldVimrc, err := os.Open(app.vimrcPath)
 		if err != nil {
@@ -133,28 +141,9 @@ func (app *_appContext) setupVimPlugins(c *cli.Context) error {
 			oldVimrcFile := path.Join(app.configDir, "_old_config.vimrc")
 			saveConfig(oldVimrcFile, app.oldVimrcBuf, true, true)
 		}
-		// save prebuilt-included vim configs except common.vimrc
-		for _confPath, _func := range _bindata {
-			fn := path.Base(_confPath)
-			if asset, err := _func(); err != nil {
-				continue
-			} else {
-				app.info("save pre-configured vimrc file: ", fn)
-				saveConfig(path.Join(app.configDir, fn), asset.bytes, false || app.forceUpdate, false)
-			}
-		}
-	} else {
-		// save common and prebuilt-included vim configs
-		for _confPath, _func := range _bindata {
-			confPath := path.Join(app.configDir, path.Base(_confPath))
-			if asset, err := _func(); err != nil {
-				continue
-			} else {
-				app.info("save pre-configured vimrc file: ", path.Base(_confPath))
-				saveConfig(confPath, asset.bytes, false || app.forceUpdate, false)
-			}
-		}
 	}
+	app.installBundledConfigs()
+	app.syncSources()
 
 	return app.installPluginsByConfigs()
 }
@@ -196,133 +185,234 @@ func saveConfig(_path string, _data interface{}, force, backup bool) bool {
 
 func (app *_appContext) installPathogen(installPath string) error {
 	app.info("Install pathogen ...")
-	if resp, err := http.Get(_PATHOGEN_VIM_URL); err != nil {
+	p := app.startProgress("pathogen.vim")
+	defer p.finish()
+	cached, err := app.cachedDownload(_PATHOGEN_VIM_URL)
+	if err != nil {
 		app.err("unable to download pathogen.vim. (error: %s)", err)
 		return err
-	} else {
-		defer resp.Body.Close()
-		pathogen, err := os.Create(installPath)
-		if err != nil {
-			app.err("unable to create(%s) for pathogen.vim", installPath)
-			return err
-		}
-		defer pathogen.Close()
-		if _, err := io.Copy(pathogen, resp.Body); err != nil {
-			app.err("unable to save pathogen.vim to %s", installPath)
-			return err
-		}
+	}
+	if err := dry.FileCopy(cached, installPath); err != nil {
+		app.err("unable to save pathogen.vim to %s", installPath)
+		return err
 	}
 	return nil
 }
 
-var _INSTALL_SCRIPT_BEGIN_PATTERN = regexp.MustCompile("\\s*\"\\s+@run\\-script\\s*(?:\\(([^\\)]*)\\)|)")
-var _INSTALL_SCRIPT_END_PATTERN = regexp.MustCompile("\\s*\"\\s+@end\\-script")
-var _INSTALL_SCRIPT_LINE_PATTERN = regexp.MustCompile("\\s*\"(.*)")
-var _INSTALL_PLUGIN_PATTERN = regexp.MustCompile("\\s*\"\\s+@require(?:\\-plugin|)\\s*:\\s*(.*)")
-
-func (app *_appContext) _writeVimSource(configfile string) {
+func _vimSourcePath(configfile string) string {
 	if u, err := user.Current(); err == nil {
 		if strings.HasPrefix(configfile, u.HomeDir) {
 			configfile = "~/" + strings.TrimLeft(configfile, u.HomeDir)
 		}
 	}
-	sourcefrom := "so " + configfile + "\n"
+	return configfile
+}
+
+func (app *_appContext) _writeVimSource(configfile string) {
+	sourcefrom := "so " + _vimSourcePath(configfile) + "\n"
 	app.vimrcBuf.WriteString(sourcefrom)
 }
 
+// _writeSkippedVimSource leaves the source line of a skipped config commented
+// out, with the reason, the next setup sources it again once it's fixed
+func (app *_appContext) _writeSkippedVimSource(configfile, reason string) {
+	app.vimrcBuf.WriteString("\" so " + _vimSourcePath(configfile) + " \" skipped: " + reason + "\n")
+}
+
 func (app *_appContext) installPluginsByConfigs() error {
-	commonRc := path.Join(app.configDir, "common.vimrc")
-	if dry.FileExists(commonRc) {
-		app._writeVimSource(commonRc)
+	profile, err := app.profileConfigs()
+	if err != nil {
+		app.err("%s", err)
+		return err
+	}
+	inProfile := func(name string) bool {
+		return profile == nil || profile[name]
+	}
+
+	if common := app.resolveConfigs()["common.vimrc"]; common != nil && common.path != "" {
+		if inProfile(common.name) {
+			app._writeVimSource(common.path)
+		}
 	} else {
 		oldVimrc := path.Join(app.configDir, "_old_config.vimrc")
-		if dry.FileExists(oldVimrc) {
+		if dry.FileExists(oldVimrc) && inProfile(path.Base(oldVimrc)) {
 			app._writeVimSource(oldVimrc)
 		}
 	}
 
-	fl, err := dry.ListDirFiles(app.configDir)
+	allConfigs, err := app.parseVimConfigs()
+	if err != nil {
+		return err
+	}
+	configs := []*vimConfig{}
+	for _, config := range allConfigs {
+		if inProfile(config.name) {
+			configs = append(configs, config)
+		}
+	}
+	if profile != nil {
+		app.info("use profile %s: %d of %d vim configs", app.states.Profile, len(configs), len(allConfigs))
+	}
+
+	manifest, err := app.loadManifest()
 	if err != nil {
 		return err
 	}
+	plan := app.planPlugins(manifest, configs)
 
-	for _, f := range fl {
-		if f == "common.vimrc" {
+	for _, config := range configs {
+		if plan.disabledConfigs[config.name] {
+			app.info("skip vim config %s, its plugin is disabled", config.name)
 			continue
 		}
-		configfile := path.Join(app.configDir, f)
-		err := app.installPluginByConfig(configfile)
-		if err != nil {
+		if err := app.installPluginByConfig(config, plan); err != nil {
+			// vim would fail on the missing commands and mappings
+			app.warn("skip vim config %s, %s", config.name, err)
+			app.fail("config", config.name, config.name, fmt.Errorf("not sourced, %s", err))
+			app._writeSkippedVimSource(config.path, err.Error())
 			continue
 		}
+		app._writeVimSource(config.path)
+	}
 
-		app._writeVimSource(configfile)
+	for _, spec := range plan.unattached() {
+		if spec.config != "" && !inProfile(spec.config) {
+			continue
+		}
+		app.installPlugin(spec)
 	}
 
 	return app.flushVimrc()
 }
 
+// isVimConfigFile tells if a file in config dir should be sourced, backups
+// and files left by upgrades are not
+func isVimConfigFile(name string) bool {
+	return strings.HasSuffix(name, ".vimrc") && !strings.HasPrefix(name, ".")
+}
+
+// parseVimConfigs parses every active vim config except common.vimrc, they
+// are resolved from the config layers
+func (app *_appContext) parseVimConfigs() ([]*vimConfig, error) {
+	if !dry.FileIsDir(app.configDir) {
+		return nil, errors.New("no such directory: " + app.configDir)
+	}
+
+	configs := []*vimConfig{}
+	for _, resolved := range app.activeConfigs() {
+		if resolved.name == "common.vimrc" {
+			continue
+		}
+		config, err := parseVimConfig(resolved.path)
+		if err != nil {
+			app.err("unable to parse vim config %s (error: %s)", resolved.name, err)
+			app.fail("config", resolved.name, resolved.name, err)
+			continue
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
 func (app *_appContext) flushVimrc() error {
 	app.vimrcBuf.WriteString("\n")
 	if saveConfig(app.vimrcPath, app.vimrcBuf, true, false) {
+		app.event("file.written", eventFields{"path": app.vimrcPath})
 		return nil
 	} else {
 		return errors.New("fails to update .vimrc")
 	}
 }
 
-func (app *_appContext) installPluginByConfig(configFilepath string) error {
-	configName := path.Base(configFilepath)
-	file, err := os.Open(configFilepath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	installScript := bytes.NewBufferString("")
-
-	scanner := bufio.NewScanner(file)
+const (
+	_DIRECTIVE_REQUIRE          = "require"
+	_DIRECTIVE_OPTIONAL_REQUIRE = "optional-require"
+	_DIRECTIVE_SCRIPT           = "run-script"
+)
 
-	scriptBegin := false
-	scriptEnd := false
+// configDirective is a '@require', '@optional-require' or a '@run-script'
+// block found in the comments of a vim config, value is the plugin or the
+// script body
+// configDirective is a directive of a vim config, line and col are where its
+// value starts
+type configDirective struct {
+	line  int
+	col   int
+	kind  string
+	value string
+}
 
-	app.info("parse vim config file:", configName)
+type vimConfig struct {
+	name       string
+	path       string
+	directives []configDirective
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if _INSTALL_SCRIPT_BEGIN_PATTERN.MatchString(line) {
-			scriptBegin = true
-			scriptEnd = false
-			installScript.Reset()
-			continue
-		}
-		if _INSTALL_SCRIPT_END_PATTERN.MatchString(line) {
-			scriptBegin = false
-			scriptEnd = true
-			app.runScript(installScript, configName)
-			continue
+// requires returns the plugins required by the config, the optional ones
+// included
+func (config *vimConfig) requires() []string {
+	plugins := []string{}
+	for _, d := range config.directives {
+		if d.kind == _DIRECTIVE_REQUIRE || d.kind == _DIRECTIVE_OPTIONAL_REQUIRE {
+			plugins = append(plugins, d.value)
 		}
-		if _INSTALL_PLUGIN_PATTERN.MatchString(line) {
-			ss := _INSTALL_PLUGIN_PATTERN.FindStringSubmatch(line)
-			plugin := ss[1]
-			app.printf("install plugin: %s\n", plugin)
-			app.installPlugin(plugin)
-			continue
+	}
+	return plugins
+}
+
+// optional tells if the config works without the plugin, i.e. it's only
+// required by '@optional-require'
+func (config *vimConfig) optional(plugin string) bool {
+	for _, d := range config.directives {
+		if d.kind == _DIRECTIVE_REQUIRE && d.value == plugin {
+			return false
 		}
-		if scriptBegin && !scriptEnd {
-			ss := _INSTALL_SCRIPT_LINE_PATTERN.FindStringSubmatch(line)
-			if len(ss) > 0 {
-				installScript.WriteString(ss[1])
-				installScript.WriteString("\n")
+	}
+	return true
+}
+
+// installPluginByConfig installs the plugins of a config and runs its scripts,
+// the error tells that a required plugin failed so it must not be sourced;
+// the failed scripts and optional plugins are only reported
+func (app *_appContext) installPluginByConfig(config *vimConfig, plan *pluginPlan) error {
+	app.verbose("parse vim config file:", config.name)
+	failed := []string{}
+
+	for _, d := range config.directives {
+		switch d.kind {
+		case _DIRECTIVE_REQUIRE, _DIRECTIVE_OPTIONAL_REQUIRE:
+			spec := plan.lookup(d.value)
+			if !spec.enabled {
+				continue
 			}
+			app.verbose("install plugin: %s", d.value)
+			if err := app.installPlugin(spec); err != nil {
+				if d.kind == _DIRECTIVE_OPTIONAL_REQUIRE {
+					app.warn("optional plugin %s of %s failed, source it anyway", d.value, config.name)
+					continue
+				}
+				failed = append(failed, d.value)
+			}
+		case _DIRECTIVE_SCRIPT:
+			app.runScript(bytes.NewBufferString(d.value), config.name, "")
 		}
 	}
 
+	if len(failed) > 0 {
+		return fmt.Errorf("required plugin(s) failed: %s", strings.Join(failed, ", "))
+	}
 	return nil
 }
 
-func (app *_appContext) runScript(installScript *bytes.Buffer, configName string) error {
+// runScript runs a script of a vim config or the build command of a plugin,
+// once per content; workDir is where it runs in restricted mode
+func (app *_appContext) runScript(installScript *bytes.Buffer, configName, workDir string) (err error) {
 	defer installScript.Reset()
+	defer func() {
+		if err != nil {
+			app.fail("script", configName, configName, err)
+		}
+	}()
 	if installScript.Len() > 0 {
 
 		tmpfile, err := ioutil.TempFile(app.tmpDir, ".script-")
@@ -335,32 +425,68 @@ func (app *_appContext) runScript(installScript *bytes.Buffer, configName string
 		}
 
 		cksum := fmt.Sprintf("%x", md5.Sum(installScript.Bytes()))
-		scriptName := configName + "@" + cksum
-		if !app.getBoolState("script:"+scriptName) || app.forceUpdate {
+		if !app.scriptState(configName, cksum).succeeded() || app.forceUpdate {
+			if err := app.checkScriptApproval(configName, cksum, installScript.String()); err != nil {
+				return err
+			}
 			app.info("run script inside \"%s\"...", configName)
-			if app.enableDebug {
-				app.println(installScript.String())
+			app.debug("script of %s:\n%s", configName, installScript.String())
+
+			logDir := path.Join(app.vimDir, "logs")
+			os.MkdirAll(logDir, 0755)
+			logPath := path.Join(logDir, configName+"@"+cksum+".log")
+			logFile, err := os.Create(logPath)
+			if err != nil {
+				app.err("unable to create script log %s (error: %s)", logPath, err)
+				return err
+			}
+			defer logFile.Close()
+
+			cmd := app.scriptCommand(tmpfile.Name(), workDir)
+			cmd.Stdout, cmd.Stderr = logFile, logFile
+			if stdout, stderr := app.childOutput(); stdout != nil {
+				cmd.Stdout = io.MultiWriter(logFile, stdout)
+				cmd.Stderr = io.MultiWriter(logFile, stderr)
 			}
-			cmd := exec.Command("/bin/bash", tmpfile.Name())
-			cmd.Env = append(os.Environ(),
-				"HOST_OS="+runtime.GOOS,
-				"HOST_ARCH="+runtime.GOARCH,
-				"VIMDIR="+path.Dir(app.bundleDir),
-			)
-			cmd.Stdin = os.Stdin
-			if app.enableDebug {
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
+
+			state := &scriptState{
+				Config:  configName,
+				Hash:    cksum,
+				LastRun: time.Now(),
+				LogPath: logPath,
 			}
-			if err := cmd.Run(); err != nil {
-				app.err("run script failed (%s)", err)
-				app.setState("script:"+scriptName, false)
+			p := app.startProgress("script " + configName)
+			err = app.runScriptCommand(cmd)
+			p.finish()
+			state.Duration = time.Since(state.LastRun)
+			state.ExitCode = exitCode(cmd, err)
+			app.setScriptState(state)
+			app.event("script.ran", eventFields{
+				"config":      configName,
+				"hash":        cksum,
+				"exit_code":   state.ExitCode,
+				"duration_ms": state.Duration.Nanoseconds() / int64(time.Millisecond),
+				"log":         logPath,
+			})
+			if err != nil {
+				app.err("run script failed (%s), see %s", err, logPath)
 				return err
 			} else {
 				app.success("run script successfully")
-				app.setState("script:"+scriptName, true)
 			}
 		}
 	}
 	return nil
 }
+
+// exitCode returns the exit status of a finished command, or -1 if it could not
+// be started or was killed
+func exitCode(cmd *exec.Cmd, err error) int {
+	if cmd.ProcessState != nil {
+		return cmd.ProcessState.ExitCode()
+	}
+	if err != nil {
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/ungerik/go-dry"
+)
+
+// sourceState is a git repository of team vim configs, it's pinned to the
+// commit recorded here until 'source update'
+type sourceState struct {
+	URL       string    `yaml:"url"`
+	Subdir    string    `yaml:"subdir,omitempty"`
+	Commit    string    `yaml:"commit"`
+	UpdatedAt time.Time `yaml:"updated_at"`
+}
+
+var sourceCommand = cli.Command{
+	Name:  "source",
+	Usage: "manage git repositories of shared vim configs",
+	Subcommands: []cli.Command{
+		{
+			Name:      "add",
+			Usage:     "clone a repository of vim configs",
+			ArgsUsage: "<git-url>",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "subdir",
+					Usage: "directory of the vim configs inside the repository",
+				},
+				cli.StringFlag{
+					Name:  "name",
+					Usage: "name of the source, the repository name by default",
+				},
+			},
+			Action: func(c *cli.Context) {
+				if len(c.Args()) != 1 {
+					getApp(c).fatal("missing git url of the source")
+				}
+				app, done := beginUpdate(c)
+				defer done()
+				url := c.Args().First()
+				name := c.String("name")
+				if name == "" {
+					name = strings.TrimSuffix(path.Base(strings.TrimRight(url, "/")), ".git")
+				}
+				if err := app.addSource(name, url, strings.Trim(c.String("subdir"), "/")); err != nil {
+					app.err("unable to add source %s (error: %s)", name, err)
+					app.failCommand(err)
+					return
+				}
+				app.success("source %s is added, run '%s setup' to apply it", name, app.cmdName)
+			},
+		},
+		{
+			Name:      "update",
+			Usage:     "pull the latest vim configs of source(s)",
+			ArgsUsage: "[<name> ...]",
+			Action: func(c *cli.Context) {
+				app, done := beginUpdate(c)
+				defer done()
+				names := c.Args()
+				if len(names) == 0 {
+					names = app.sourceNames()
+				}
+				for _, name := range names {
+					if err := app.updateSource(name); err != nil {
+						app.err("unable to update source %s (error: %s)", name, err)
+						app.fail("source", name, "", err)
+						continue
+					}
+					app.success("source %s is at %s", name, shortCommit(app.states.Sources[name].Commit))
+				}
+			},
+		},
+		{
+			Name:      "remove",
+			Usage:     "remove source(s)",
+			Aliases:   []string{"rm"},
+			ArgsUsage: "<name> [<name> ...]",
+			Action: func(c *cli.Context) {
+				app, done := beginUpdate(c)
+				defer done()
+				for _, name := range c.Args() {
+					if app.states.Sources[name] == nil {
+						app.err("no such source: %s", name)
+						app.fail("source", name, "", errors.New("no such source"))
+						continue
+					}
+					if validSourceName(name) == nil {
+						os.RemoveAll(app.sourceDir(name))
+					}
+					delete(app.states.Sources, name)
+					app.success("source %s is removed", name)
+				}
+			},
+		},
+		{
+			Name:    "list",
+			Usage:   "list sources",
+			Aliases: []string{"ls"},
+			Action: func(c *cli.Context) {
+				app := getApp(c)
+				if !app.jsonOutput {
+					fmt.Println("List sources:")
+				}
+				for _, name := range app.sourceNames() {
+					source := app.states.Sources[name]
+					if app.jsonOutput {
+						app.event("source", eventFields{"name": name, "url": source.URL, "commit": source.Commit, "subdir": source.Subdir})
+						continue
+					}
+					fmt.Printf("  %-16s %s %s", name, shortCommit(source.Commit), source.URL)
+					if source.Subdir != "" {
+						fmt.Printf(" (%s)", source.Subdir)
+					}
+					fmt.Println()
+				}
+			},
+		},
+	},
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 8 {
+		return commit[:8]
+	}
+	return commit
+}
+
+func (app *_appContext) sourceDir(name string) string {
+	return path.Join(app.vimDir, "sources", name)
+}
+
+// sourceConfigDir returns the directory holding the vim configs of a source
+func (app *_appContext) sourceConfigDir(name string) string {
+	return path.Join(app.sourceDir(name), app.states.Sources[name].Subdir)
+}
+
+func (app *_appContext) sourceNames() []string {
+	names := []string{}
+	for name := range app.states.Sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validSourceName rejects the names which would put the source outside the
+// sources directory, or over all of them
+func validSourceName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return fmt.Errorf("invalid source name '%s', set one with --name", name)
+	}
+	return nil
+}
+
+func (app *_appContext) addSource(name, url, subdir string) error {
+	if err := validSourceName(name); err != nil {
+		return err
+	}
+	if app.states.Sources[name] != nil {
+		return fmt.Errorf("source %s exists already", name)
+	}
+	dir := app.sourceDir(name)
+	if _, err := os.Lstat(dir); err == nil {
+		// not created by this source, leave it to the user
+		return fmt.Errorf("%s exists already, remove it or choose another --name", dir)
+	}
+	os.MkdirAll(path.Dir(dir), 0755)
+	app.info("Cloning", url)
+	if err := app.gitClone(url, dir); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	if !dry.FileIsDir(path.Join(dir, subdir)) {
+		os.RemoveAll(dir)
+		return fmt.Errorf("no such directory in the repository: %s", subdir)
+	}
+	app.states.Sources[name] = &sourceState{
+		URL:       stripSecret(url),
+		Subdir:    subdir,
+		Commit:    gitOutput(dir, "rev-parse", "HEAD"),
+		UpdatedAt: time.Now(),
+	}
+	return nil
+}
+
+func (app *_appContext) updateSource(name string) error {
+	source := app.states.Sources[name]
+	if source == nil {
+		return fmt.Errorf("no such source")
+	}
+	if err := validSourceName(name); err != nil {
+		return err
+	}
+	dir := app.sourceDir(name)
+	if !dry.FileIsDir(path.Join(dir, ".git")) {
+		app.info("Cloning", source.URL)
+		os.RemoveAll(dir)
+		if err := app.gitClone(source.URL, dir); err != nil {
+			return err
+		}
+	} else {
+		app.info("Updating", source.URL)
+		if err := app.gitFetch(dir, source.URL); err != nil {
+			return err
+		}
+	}
+	if err := app.gitCommand(dir, "checkout", "-q", "--detach", "origin/HEAD").Run(); err != nil {
+		return err
+	}
+	source.Commit = gitOutput(dir, "rev-parse", "HEAD")
+	source.UpdatedAt = time.Now()
+	return nil
+}
+
+// syncSources makes sure every source is checked out at its pinned commit
+func (app *_appContext) syncSources() {
+	for _, name := range app.sourceNames() {
+		source := app.states.Sources[name]
+		dir := app.sourceDir(name)
+		if !dry.FileIsDir(path.Join(dir, ".git")) {
+			app.info("Cloning", source.URL)
+			if err := app.gitClone(source.URL, dir); err != nil {
+				app.err("unable to clone source %s (error: %s)", name, err)
+				app.fail("source", name, "", err)
+				continue
+			}
+		}
+		if gitOutput(dir, "rev-parse", "HEAD") == source.Commit {
+			continue
+		}
+		if err := app.gitCommand(dir, "checkout", "-q", "--detach", source.Commit).Run(); err != nil {
+			// the pinned commit may be fetched from the remote only
+			app.gitFetch(dir, source.URL)
+			if err := app.gitCommand(dir, "checkout", "-q", "--detach", source.Commit).Run(); err != nil {
+				err = app.gitError(err)
+				app.err("unable to checkout %s of source %s (error: %s)", shortCommit(source.Commit), name, err)
+				app.fail("source", name, "", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/ungerik/go-dry"
+)
+
+// testGit runs git in dir and returns its trimmed output
+func testGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s (%s)", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// testSourceRepo creates a bare repository of vim configs and a working copy
+// to push new commits to it
+func testSourceRepo(t *testing.T) (string, string) {
+	tmp := t.TempDir()
+	bare, work := path.Join(tmp, "team.git"), path.Join(tmp, "work")
+	testGit(t, tmp, "init", "-q", "--bare", bare)
+	testGit(t, tmp, "clone", "-q", bare, work)
+	testCommitConfig(t, work, "go.vimrc", "set nu\n")
+	return bare, work
+}
+
+func testCommitConfig(t *testing.T, work, name, content string) string {
+	os.MkdirAll(path.Join(work, "vim"), 0755)
+	if err := ioutil.WriteFile(path.Join(work, "vim", name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testGit(t, work, "add", "-A")
+	testGit(t, work, "commit", "-q", "-m", "update "+name)
+	testGit(t, work, "push", "-q", "origin", "HEAD")
+	return testGit(t, work, "rev-parse", "HEAD")
+}
+
+func testSourceApp(t *testing.T) *_appContext {
+	tmp := t.TempDir()
+	app := &_appContext{
+		logger:   newLogger(_LEVEL_QUIET),
+		vimDir:   path.Join(tmp, "vim"),
+		tmpDir:   path.Join(tmp, "vim", "tmp"),
+		cacheDir: path.Join(tmp, "cache"),
+		settings: &vimSettings{Retry: retrySettings{Attempts: 1}},
+		states:   newVimStates(),
+	}
+	os.MkdirAll(app.vimDir, 0755)
+	return app
+}
+
+func TestSourceAddUpdatePin(t *testing.T) {
+	bare, work := testSourceRepo(t)
+	app := testSourceApp(t)
+	url := "file://" + bare
+
+	if err := app.addSource("team", url, "vim"); err != nil {
+		t.Fatal(err)
+	}
+	first := app.states.Sources["team"].Commit
+	if first == "" || !dry.FileExists(path.Join(app.sourceConfigDir("team"), "go.vimrc")) {
+		t.Fatalf("source is not cloned: %+v", app.states.Sources["team"])
+	}
+	if err := app.addSource("team", url, "vim"); err == nil {
+		t.Error("adding a source twice should fail")
+	}
+
+	second := testCommitConfig(t, work, "ycm.vimrc", "set ts=4\n")
+	if err := app.updateSource("team"); err != nil {
+		t.Fatal(err)
+	}
+	if commit := app.states.Sources["team"].Commit; commit != second {
+		t.Errorf("commit after update = %s, want %s", commit, second)
+	}
+	if !dry.FileExists(path.Join(app.sourceConfigDir("team"), "ycm.vimrc")) {
+		t.Error("update didn't check out the new config")
+	}
+
+	// the source goes back to the commit pinned in states
+	app.states.Sources["team"].Commit = first
+	app.syncSources()
+	if head := testGit(t, app.sourceDir("team"), "rev-parse", "HEAD"); head != first {
+		t.Errorf("HEAD after sync = %s, want the pinned %s", head, first)
+	}
+	if len(app.failures) != 0 {
+		t.Errorf("unexpected failures: %v", app.failures)
+	}
+}
+
+func TestSourceAddMissingSubdir(t *testing.T) {
+	bare, _ := testSourceRepo(t)
+	app := testSourceApp(t)
+	if err := app.addSource("team", "file://"+bare, "nosuch"); err == nil {
+		t.Fatal("a missing subdir should fail")
+	}
+	if dry.FileExists(app.sourceDir("team")) || app.states.Sources["team"] != nil {
+		t.Error("the failed source is left behind")
+	}
+}
+
+func TestSourceAddInvalidName(t *testing.T) {
+	bare, _ := testSourceRepo(t)
+	app := testSourceApp(t)
+	marker := path.Join(app.vimDir, "keep")
+	ioutil.WriteFile(marker, nil, 0644)
+	os.MkdirAll(path.Join(app.vimDir, "sources", "mine"), 0755)
+	mine := path.Join(app.vimDir, "sources", "mine", "keep")
+	ioutil.WriteFile(mine, nil, 0644)
+
+	for _, name := range []string{"", ".", "..", "a/b", "../x", "mine"} {
+		if err := app.addSource(name, "file:///nonexistent", ""); err == nil {
+			t.Errorf("addSource(%q) should fail", name)
+		}
+	}
+	if !dry.FileExists(marker) || !dry.FileExists(mine) {
+		t.Error("an invalid source removed files it didn't create")
+	}
+	if err := app.addSource("team", "file://"+bare, ""); err != nil {
+		t.Errorf("a valid source should be added: %s", err)
+	}
+}
@@ -1,54 +1,226 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
 	"path"
+	"strings"
+	"time"
 
+	"github.com/ungerik/go-dry"
 	"gopkg.in/yaml.v2"
 )
 
-func (app *_appContext) loadStates() {
-	stateFile := path.Join(app.vimDir, "states.yml")
-	data, err := ioutil.ReadFile(stateFile)
+// _STATES_VERSION is the schema version of states.yml, bump it and add a
+// migration to loadStates when the layout changes
+const _STATES_VERSION = 2
+
+type pluginState struct {
+	URL         string    `yaml:"url"`
+	Ref         string    `yaml:"ref,omitempty"`
+	Commit      string    `yaml:"commit,omitempty"`
+	InstalledAt time.Time `yaml:"installed_at"`
+	Config      string    `yaml:"config,omitempty"`
+	Linked      bool      `yaml:"linked,omitempty"`
+}
+
+type scriptState struct {
+	Config   string        `yaml:"config"`
+	Hash     string        `yaml:"hash"`
+	LastRun  time.Time     `yaml:"last_run"`
+	ExitCode int           `yaml:"exit_code"`
+	Duration time.Duration `yaml:"duration"`
+	LogPath  string        `yaml:"log_path,omitempty"`
+	// Rerun is set by 'import-bundle', the script has to run on the new host
+	Rerun bool `yaml:"rerun,omitempty"`
+}
+
+func (s *scriptState) succeeded() bool {
+	return s != nil && s.ExitCode == 0 && !s.Rerun
+}
+
+type vimStates struct {
+	Version         int                        `yaml:"version"`
+	Plugins         map[string]*pluginState    `yaml:"plugins"`
+	Scripts         map[string]*scriptState    `yaml:"scripts"`
+	DisabledConfigs []string                   `yaml:"disabled_configs,omitempty"`
+	Configs         map[string]*configState    `yaml:"configs,omitempty"`
+	Sources         map[string]*sourceState    `yaml:"sources,omitempty"`
+	Profile         string                     `yaml:"profile,omitempty"`
+	Approvals       map[string]*scriptApproval `yaml:"approvals,omitempty"`
+}
+
+func newVimStates() *vimStates {
+	return &vimStates{
+		Version:   _STATES_VERSION,
+		Plugins:   make(map[string]*pluginState),
+		Scripts:   make(map[string]*scriptState),
+		Configs:   make(map[string]*configState),
+		Sources:   make(map[string]*sourceState),
+		Approvals: make(map[string]*scriptApproval),
+	}
+}
+
+func (app *_appContext) stateFile() string {
+	return path.Join(app.vimDir, "states.yml")
+}
+
+// loadStates reads states.yml, a missing file is an empty state but any other
+// error is reported and returned, the caller must not overwrite the file then
+func (app *_appContext) loadStates() error {
+	app.states = newVimStates()
+	data, err := ioutil.ReadFile(app.stateFile())
 	if err != nil {
-		return
+		if os.IsNotExist(err) {
+			return nil
+		}
+		app.err("unable to read %s (error: %s)", app.stateFile(), err)
+		return err
 	}
 
-	if yaml.Unmarshal(data, &app.states) != nil {
-		return
+	var header struct {
+		Version int `yaml:"version"`
+	}
+	if err := yaml.Unmarshal(data, &header); err != nil {
+		app.err("%s is corrupted (error: %s)", app.stateFile(), err)
+		return err
 	}
-}
 
-func (app *_appContext) saveStates() {
-	stateFile := path.Join(app.vimDir, "states.yml")
-	data, err := yaml.Marshal(&app.states)
+	switch {
+	case header.Version == 0:
+		if err = app.states.migrateV1(data); err == nil {
+			app.completeMigratedPlugins()
+		}
+	case header.Version > _STATES_VERSION:
+		err = fmt.Errorf("unsupported version %d, please upgrade %s", header.Version, app.cmdName)
+	default:
+		err = yaml.Unmarshal(data, app.states)
+	}
 	if err != nil {
-		return
+		app.err("%s is corrupted (error: %s)", app.stateFile(), err)
+		return err
+	}
+	if app.states.Plugins == nil {
+		app.states.Plugins = make(map[string]*pluginState)
+	}
+	if app.states.Scripts == nil {
+		app.states.Scripts = make(map[string]*scriptState)
 	}
-	ioutil.WriteFile(stateFile, data, 0644)
+	if app.states.Configs == nil {
+		app.states.Configs = make(map[string]*configState)
+	}
+	if app.states.Sources == nil {
+		app.states.Sources = make(map[string]*sourceState)
+	}
+	if app.states.Approvals == nil {
+		app.states.Approvals = make(map[string]*scriptApproval)
+	}
+	app.states.Version = _STATES_VERSION
+	app.statesLoaded = true
+	return nil
 }
 
-func (app *_appContext) getStringState(key string) string {
-	if s, ok := app.states[key].(string); ok {
-		return s
+// migrateV1 converts the flat map of the old states.yml, which only recorded
+// 'plugin:<name>' and 'script:<config>@<md5>' flags
+func (states *vimStates) migrateV1(data []byte) error {
+	old := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &old); err != nil {
+		return err
 	}
-	return ""
+	for key, value := range old {
+		ok, _ := value.(bool)
+		if strings.HasPrefix(key, "plugin:") {
+			if ok {
+				states.Plugins[strings.TrimPrefix(key, "plugin:")] = &pluginState{}
+			}
+		} else if strings.HasPrefix(key, "script:") {
+			name := strings.TrimPrefix(key, "script:")
+			p := strings.LastIndex(name, "@")
+			if p < 0 {
+				return errors.New("unknown script state: " + key)
+			}
+			script := &scriptState{Config: name[:p], Hash: name[p+1:]}
+			if !ok {
+				script.ExitCode = -1
+			}
+			states.Scripts[name] = script
+		} else {
+			return errors.New("unknown state: " + key)
+		}
+	}
+	return nil
 }
 
-func (app *_appContext) getIntState(key string) int {
-	if s, ok := app.states[key].(int); ok {
-		return s
+// completeMigratedPlugins reads the url and the commit of the plugins migrated
+// from the old states.yml out of their checkouts
+func (app *_appContext) completeMigratedPlugins() {
+	for name, state := range app.states.Plugins {
+		installDir := path.Join(app.bundleDir, name)
+		if state.URL != "" || !dry.FileIsDir(path.Join(installDir, ".git")) {
+			continue
+		}
+		state.URL = stripSecret(gitOutput(installDir, "config", "--get", "remote.origin.url"))
+		state.Commit = gitOutput(installDir, "rev-parse", "HEAD")
+		if ref := gitOutput(installDir, "rev-parse", "--abbrev-ref", "HEAD"); ref != "HEAD" {
+			state.Ref = ref
+		}
 	}
-	return 0
 }
 
-func (app *_appContext) getBoolState(key string) bool {
-	if s, ok := app.states[key].(bool); ok {
-		return s
+// saveStates writes states.yml atomically, it refuses to overwrite a file
+// which could not be loaded to avoid losing the recorded states
+func (app *_appContext) saveStates() error {
+	if !app.statesLoaded && stateFileExists(app.stateFile()) {
+		err := errors.New("states were not loaded")
+		app.err("refuse to overwrite %s (error: %s)", app.stateFile(), err)
+		return err
+	}
+	data, err := yaml.Marshal(app.states)
+	if err != nil {
+		app.err("unable to encode states (error: %s)", err)
+		return err
+	}
+	tmpFile := app.stateFile() + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, data, 0644); err != nil {
+		app.err("unable to write %s (error: %s)", tmpFile, err)
+		return err
+	}
+	if err := os.Rename(tmpFile, app.stateFile()); err != nil {
+		os.Remove(tmpFile)
+		app.err("unable to write %s (error: %s)", app.stateFile(), err)
+		return err
 	}
-	return false
+	app.statesLoaded = true
+	return nil
+}
+
+func stateFileExists(stateFile string) bool {
+	_, err := os.Stat(stateFile)
+	return err == nil
+}
+
+// requireStates stops the commands which are going to update the states if
+// states.yml could not be loaded
+func (app *_appContext) requireStates() {
+	if app.statesErr != nil {
+		app.fatal("unable to load %s (error: %s), fix or remove it first", app.stateFile(), app.statesErr)
+	}
+}
+
+func (app *_appContext) pluginState(name string) *pluginState {
+	return app.states.Plugins[name]
+}
+
+func (app *_appContext) setPluginState(name string, state *pluginState) {
+	app.states.Plugins[name] = state
+}
+
+func (app *_appContext) scriptState(config, hash string) *scriptState {
+	return app.states.Scripts[config+"@"+hash]
 }
 
-func (app *_appContext) setState(key string, value interface{}) {
-	app.states[key] = value
+func (app *_appContext) setScriptState(state *scriptState) {
+	app.states.Scripts[state.Config+"@"+state.Hash] = state
 }
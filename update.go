@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/codegangsta/cli"
+)
+
+var updateCommand = cli.Command{
+	Name:      "update",
+	Usage:     "pull the latest commits of installed plugin(s), linked plugins are skipped",
+	ArgsUsage: "[<name> ...]",
+	Action: func(c *cli.Context) {
+		getApp(c).checkPrerequisites()
+		app, done := beginUpdate(c)
+		defer done()
+		plan, err := app.currentPlan()
+		if err != nil {
+			app.fatal("unable to read vim configs (error: %s)", err)
+		}
+		names := c.Args()
+		if len(names) == 0 {
+			names = app.installedPlugins()
+		}
+		for _, name := range names {
+			state := app.pluginState(name)
+			if state == nil {
+				app.err("%s is not installed", name)
+				app.fail("plugin", name, "", errors.New("not installed"))
+				continue
+			}
+			if state.Linked {
+				app.info("%s is linked, skip it", name)
+				continue
+			}
+			url := state.URL
+			if url == "" {
+				// migrated from the old states.yml without a checkout to read
+				url = gitOutput(path.Join(app.bundleDir, name), "config", "--get", "remote.origin.url")
+			}
+			if merged, ok := plan.specs[name]; ok && url == "" {
+				url = merged.url
+			}
+			if url == "" {
+				app.err("unknown url of %s, reinstall it", name)
+				app.fail("plugin", name, "", errors.New("unknown url"))
+				continue
+			}
+			spec := *plan.lookup(url)
+			spec.update, spec.link = true, false
+			if spec.config == "" {
+				spec.config = state.Config
+			}
+			if app.installPlugin(&spec) == nil {
+				app.success("%s is at %s", name, shortCommit(app.pluginState(name).Commit))
+			}
+		}
+	},
+}
+
+var cleanCommand = cli.Command{
+	Name:  "clean",
+	Usage: "remove the plugins no vim config or plugins.yml requires, linked plugins are kept",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "dry-run,n",
+			Usage: "only print the plugins to remove",
+		},
+	},
+	Action: func(c *cli.Context) {
+		app, done := beginUpdate(c)
+		defer done()
+		plan, err := app.currentPlan()
+		if err != nil {
+			app.fatal("unable to read vim configs (error: %s)", err)
+		}
+		fis, err := ioutil.ReadDir(app.bundleDir)
+		if err != nil && !os.IsNotExist(err) {
+			app.fatal("cannot access to '%s' (error: %s)", app.bundleDir, err)
+		}
+		bundles := make(map[string]bool)
+		for _, fi := range fis {
+			name := fi.Name()
+			bundles[name] = true
+			if spec, ok := plan.specs[name]; ok && spec.enabled {
+				continue
+			}
+			if state := app.pluginState(name); fi.Mode()&os.ModeSymlink != 0 || (state != nil && state.Linked) {
+				app.debug("keep linked plugin", name)
+				continue
+			}
+			if c.Bool("dry-run") {
+				app.info("would remove %s", name)
+				continue
+			}
+			if err := os.RemoveAll(path.Join(app.bundleDir, name)); err != nil {
+				app.err("unable to remove %s (error: %s)", name, err)
+				app.fail("plugin", name, "", err)
+				continue
+			}
+			delete(app.states.Plugins, name)
+			app.success("%s is removed", name)
+		}
+		if c.Bool("dry-run") {
+			return
+		}
+		// forget the plugins removed by hand
+		for name := range app.states.Plugins {
+			if !bundles[name] {
+				delete(app.states.Plugins, name)
+			}
+		}
+	},
+}
+
+// currentPlan merges the manifest and the '@require' directives of every
+// active vim config, whatever the profile
+func (app *_appContext) currentPlan() (*pluginPlan, error) {
+	configs, err := app.parseVimConfigs()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := app.loadManifest()
+	if err != nil {
+		return nil, err
+	}
+	return app.planPlugins(manifest, configs), nil
+}
+
+// installedPlugins returns the names of the plugins recorded in states
+func (app *_appContext) installedPlugins() []string {
+	names := []string{}
+	for name := range app.states.Plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/ungerik/go-dry"
+)
+
+// configState records the bundled version a vim config was installed from, a
+// copy of it is kept in bundledBaseDir as the base of the next upgrade
+type configState struct {
+	AssetHash string `yaml:"asset_hash"`
+	Pending   string `yaml:"pending,omitempty"`
+}
+
+func assetHash(data []byte) string {
+	return fmt.Sprintf("%x", md5.Sum(data))
+}
+
+func (app *_appContext) bundledBaseDir() string {
+	return path.Join(app.vimDir, ".bundled")
+}
+
+func (app *_appContext) configState(name string) *configState {
+	return app.states.Configs[name]
+}
+
+// recordBundledBase remembers asset as the installed version of the config
+func (app *_appContext) recordBundledBase(name string, asset []byte) {
+	os.MkdirAll(app.bundledBaseDir(), 0755)
+	if err := ioutil.WriteFile(path.Join(app.bundledBaseDir(), name), asset, 0644); err != nil {
+		app.err("unable to save the bundled version of %s (error: %s)", name, err)
+		return
+	}
+	app.states.Configs[name] = &configState{AssetHash: assetHash(asset)}
+}
+
+func (app *_appContext) bundledBase(name string) ([]byte, bool) {
+	state := app.configState(name)
+	if state == nil {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(path.Join(app.bundledBaseDir(), name))
+	if err != nil || assetHash(data) != state.AssetHash {
+		return nil, false
+	}
+	return data, true
+}
+
+// installBundledConfigs saves the bundled vim configs into config dir. A config
+// edited by the user is upgraded with a three-way merge of the installed
+// bundled version, the new one and the user's file; when they conflict the
+// user's file is kept, with <name>.orig and <name>.new beside it to merge by
+// hand. --force replaces the user's file, a backup is kept as .<name>.
+func (app *_appContext) installBundledConfigs() {
+	for _, name := range bundledConfigNames() {
+		if app.isConfigDisabled(name) {
+			continue
+		}
+		asset, _ := bundledConfig(name)
+		configPath := path.Join(app.configDir, name)
+
+		current, err := ioutil.ReadFile(configPath)
+		if err != nil {
+			app.info("save pre-configured vimrc file: ", name)
+			if saveConfig(configPath, asset, true, false) {
+				app.recordBundledBase(name, asset)
+			}
+			continue
+		}
+		if bytes.Equal(current, asset) {
+			if state := app.configState(name); state == nil || state.AssetHash != assetHash(asset) {
+				app.recordBundledBase(name, asset)
+			}
+			continue
+		}
+
+		base, ok := app.bundledBase(name)
+		if app.forceUpdate {
+			app.info("replace vimrc file: %s, your version is saved as .%s", name, name)
+			if saveConfig(configPath, asset, true, true) {
+				app.recordBundledBase(name, asset)
+				app.removeMergeFiles(name)
+			}
+			continue
+		}
+		if !ok {
+			// installed by an older version, nothing to merge with
+			app.debug("%s is modified, keep it", name)
+			continue
+		}
+		if bytes.Equal(base, asset) {
+			continue
+		}
+		if bytes.Equal(current, base) {
+			app.info("upgrade vimrc file: ", name)
+			if saveConfig(configPath, asset, true, false) {
+				app.recordBundledBase(name, asset)
+			}
+			continue
+		}
+
+		state := app.configState(name)
+		if state.Pending == assetHash(asset) {
+			app.warn("%s has unresolved changes, merge %s.new by hand then run '%s configs resolve %s'", name, name, app.cmdName, name)
+			continue
+		}
+		merged, conflicts := mergeLines(splitLines(base), splitLines(current), splitLines(asset))
+		if conflicts > 0 {
+			saveConfig(configPath+".orig", base, true, false)
+			saveConfig(configPath+".new", asset, true, false)
+			state.Pending = assetHash(asset)
+			app.warn("%s conflicts with its new bundled version in %d place(s), merge %s.new by hand then run '%s configs resolve %s'",
+				name, conflicts, name, app.cmdName, name)
+			continue
+		}
+		app.info("merge the new bundled version into your %s", name)
+		if saveConfig(configPath, joinLines(merged), true, true) {
+			app.recordBundledBase(name, asset)
+		}
+	}
+}
+
+// resolveBundledConfig marks the pending upgrade of a config as merged
+func (app *_appContext) resolveBundledConfig(name string) error {
+	asset, ok := bundledConfig(name)
+	if !ok {
+		return fmt.Errorf("%s is not a bundled vim config", name)
+	}
+	if !dry.FileExists(path.Join(app.configDir, name)) {
+		return fmt.Errorf("%s is not installed", name)
+	}
+	app.recordBundledBase(name, asset)
+	app.removeMergeFiles(name)
+	return nil
+}
+
+func (app *_appContext) removeMergeFiles(name string) {
+	configPath := path.Join(app.configDir, name)
+	os.Remove(configPath + ".orig")
+	os.Remove(configPath + ".new")
+}